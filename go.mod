@@ -3,7 +3,8 @@ module github.com/slackhq/simple-kubernetes-webhook
 go 1.16
 
 require (
-	github.com/google/go-cmp v0.5.5 // indirect
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.6.1
 	github.com/wI2L/jsondiff v0.1.0
@@ -12,4 +13,6 @@ require (
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 	k8s.io/api v0.21.3
 	k8s.io/apimachinery v0.21.3
+	k8s.io/client-go v0.21.3
+	sigs.k8s.io/yaml v1.2.0
 )
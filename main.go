@@ -2,34 +2,146 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/slackhq/simple-kubernetes-webhook/pkg/admission"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/certs"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/metrics"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/namespace"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/reload"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/server"
 	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
+// policy holds the webhook config applied to all admission requests
+var policy *reload.Config
+
+// serverConfig holds the server settings, including the limits applied to
+// admission requests
+var serverConfig *server.Config
+
+// errRequestTooLarge is returned for admission requests over the maximum
+// request size
+var errRequestTooLarge = errors.New("admission request body is too large")
+
+// namespaces caches namespace labels for rules scoped by namespace selector,
+// it is only started once such a rule is loaded and when running in a cluster
+var namespaces atomic.Value // *namespace.Cache
+
+// namespaceSyncTimeout bounds how long loading a config waits for the
+// namespace cache to sync
+const namespaceSyncTimeout = 30 * time.Second
+
+// certRenewInterval is how often bootstrapped certificates are checked for
+// renewal
+const certRenewInterval = time.Hour
+
 func main() {
 	setLogger()
+	cfg, err := server.Parse(os.Args[1:], os.Getenv)
+	if err != nil {
+		logrus.Fatalf("invalid server config: %v", err)
+	}
+	serverConfig = cfg
+	setConfig(cfg.ConfigFile)
+	watched := []string{cfg.ConfigFile}
 
-	// handle our core application
-	http.HandleFunc("/validate-pods", ServeValidatePods)
-	http.HandleFunc("/mutate-pods", ServeMutatePods)
+	// handle our core application, admission endpoints only serve
+	// authenticated callers when a client CA is set
+	var clientAuth *server.ClientAuth
+	if cfg.ClientCAFile != "" {
+		clientAuth, err = server.NewClientAuth(logrus.StandardLogger(), cfg.ClientCAFile, cfg.ClientAllowedNames)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	http.Handle("/validate-pods", clientAuth.Require(http.HandlerFunc(ServeValidatePods)))
+	http.Handle("/mutate-pods", clientAuth.Require(http.HandlerFunc(ServeMutatePods)))
+	http.Handle("/validate", clientAuth.Require(http.HandlerFunc(ServeValidate)))
+	http.Handle("/mutate", clientAuth.Require(http.HandlerFunc(ServeMutate)))
 	http.HandleFunc("/health", ServeHealth)
+	http.Handle("/metrics", promhttp.Handler())
+
+	// liveness requires a loaded policy and an unexpired serving certificate,
+	// readiness also turns unready on shutdown
+	health := &server.Health{}
+	health.AddCheck("config", func() error {
+		if policy.Get() == nil {
+			return errors.New("not loaded")
+		}
+		return nil
+	})
+	http.HandleFunc("/livez", health.ServeLivez)
+	http.HandleFunc("/readyz", health.ServeReadyz)
+
+	// serves clear text http unless TLS is enabled
+	srv := cfg.HTTPServer(nil)
+	if cfg.TLS {
+		if cfg.CertBootstrap {
+			bundle, err := bootstrapCerts(cfg)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			if err := bundle.WriteFiles(cfg.CertFile, cfg.KeyFile); err != nil {
+				logrus.Fatalf("could not write certificates: %v", err)
+			}
+			go renewCerts(cfg, bundle)
+		}
+		cert, err := reload.NewCertificate(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		health.AddCheck("certificate", server.CertificateCheck(cert.NotAfter))
+		metrics.RegisterCertificateExpiry(cert.NotAfter)
 
-	// start the server
-	// listens to clear text http on port 8080 unless TLS env var is set to "true"
-	if os.Getenv("TLS") == "true" {
-		cert := "/etc/admission-webhook/tls/tls.crt"
-		key := "/etc/admission-webhook/tls/tls.key"
-		logrus.Print("Listening on port 443...")
-		logrus.Fatal(http.ListenAndServeTLS(":443", cert, key, nil))
+		reloaders := []func() error{cert.Reload}
+		watched = append(watched, cfg.CertFile, cfg.KeyFile)
+		if clientAuth != nil {
+			reloaders = append(reloaders, clientAuth.Reload)
+			watched = append(watched, cfg.ClientCAFile)
+		}
+		watchFiles(watched, reloaders...)
+
+		srv.TLSConfig, err = cfg.TLSConfig(cert.GetCertificate)
+		if err != nil {
+			logrus.Fatal(err)
+		}
 	} else {
-		logrus.Print("Listening on port 8080...")
-		logrus.Fatal(http.ListenAndServe(":8080", nil))
+		watchFiles(watched)
+	}
+
+	// start the server, it shuts down gracefully on SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.WithField("tls", cfg.TLS).Printf("Listening on %s...", cfg.Addr)
+	err = cfg.Serve(ctx, logrus.StandardLogger(), ln, srv, health)
+	if errors.Is(err, context.DeadlineExceeded) {
+		logrus.Warnf("in-flight requests did not complete within the %s shutdown grace period", cfg.ShutdownGracePeriod)
+		return
+	}
+	if err != nil {
+		logrus.Fatal(err)
 	}
 }
 
@@ -42,62 +154,79 @@ func ServeHealth(w http.ResponseWriter, r *http.Request) {
 // ServeValidatePods validates an admission request and then writes an admission
 // review to `w`
 func ServeValidatePods(w http.ResponseWriter, r *http.Request) {
-	logger := logrus.WithField("uri", r.RequestURI)
-	logger.Debug("received validation request")
-
-	in, err := parseRequest(*r)
-	if err != nil {
-		logger.Error(err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	adm := admission.Admitter{
-		Logger:  logger,
-		Request: in.Request,
-	}
-
-	out, err := adm.ValidatePodReview()
-	if err != nil {
-		e := fmt.Sprintf("could not generate admission response: %v", err)
-		logger.Error(e)
-		http.Error(w, e, http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	jout, err := json.Marshal(out)
-	if err != nil {
-		e := fmt.Sprintf("could not parse admission response: %v", err)
-		logger.Error(e)
-		http.Error(w, e, http.StatusInternalServerError)
-		return
-	}
-
-	logger.Debug("sending response")
-	logger.Debugf("%s", jout)
-	fmt.Fprintf(w, "%s", jout)
+	serveReview(w, r, "validate-pods", admission.Admitter.ValidatePodReview)
 }
 
 // ServeMutatePods returns an admission review with pod mutations as a json patch
 // in the review response
 func ServeMutatePods(w http.ResponseWriter, r *http.Request) {
+	serveReview(w, r, "mutate-pods", admission.Admitter.MutatePodReview)
+}
+
+// ServeValidate validates an admission request for any supported kind and then
+// writes an admission review to `w`
+func ServeValidate(w http.ResponseWriter, r *http.Request) {
+	serveReview(w, r, "validate", admission.Admitter.ValidateReview)
+}
+
+// ServeMutate returns an admission review with mutations for any supported
+// kind as a json patch in the review response
+func ServeMutate(w http.ResponseWriter, r *http.Request) {
+	serveReview(w, r, "mutate", admission.Admitter.MutateReview)
+}
+
+// serveReview parses an admission request, hands it over to the given review
+// func and writes the resulting admission review to `w`. Outcomes and latency
+// are recorded under the given endpoint name.
+func serveReview(w http.ResponseWriter, r *http.Request, endpoint string,
+	review func(admission.Admitter, context.Context) (*admissionv1.AdmissionReview, error)) {
+	start := time.Now()
+	defer func() {
+		metrics.RequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	}()
+
 	logger := logrus.WithField("uri", r.RequestURI)
-	logger.Debug("received mutation request")
+	logger.Debugf("received %s request", endpoint)
 
-	in, err := parseRequest(*r)
+	in, err := parseRequest(r, serverConfig.MaxRequestBytes)
 	if err != nil {
 		logger.Error(err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		metrics.Requests.WithLabelValues(endpoint, "", "", metrics.OutcomeError).Inc()
+		status := http.StatusBadRequest
+		if errors.Is(err, errRequestTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
+	// enforced rules still running past the deadline deny the request,
+	// rather than having the API server time out and apply the webhook
+	// failure policy at an arbitrary point
+	ctx, cancel := context.WithTimeout(r.Context(), serverConfig.AdmissionTimeout)
+	defer cancel()
 	adm := admission.Admitter{
-		Logger:  logger,
-		Request: in.Request,
+		Logger:     logger,
+		Request:    in.Request,
+		Policy:     policy.Get(),
+		Namespaces: namespaceLabeler(),
 	}
 
-	out, err := adm.MutatePodReview()
+	outcome := metrics.OutcomeError
+	defer func() {
+		metrics.Requests.WithLabelValues(endpoint, string(in.Request.Operation),
+			in.Request.Namespace, outcome).Inc()
+	}()
+
+	out, err := review(adm, ctx)
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	if timedOut {
+		// answered with a denying review, the API server would apply the
+		// webhook failure policy to an error response
+		e := fmt.Sprintf("admission request timed out after %s: %v", serverConfig.AdmissionTimeout, err)
+		logger.Error(e)
+		out, err = admission.TimeoutReview(in.Request.UID, e), nil
+	}
 	if err != nil {
 		e := fmt.Sprintf("could not generate admission response: %v", err)
 		logger.Error(e)
@@ -114,6 +243,15 @@ func ServeMutatePods(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch {
+	case timedOut:
+		outcome = metrics.OutcomeTimeout
+	case out.Response.Allowed:
+		outcome = metrics.OutcomeAllowed
+	default:
+		outcome = metrics.OutcomeDenied
+	}
+
 	logger.Debug("sending response")
 	logger.Debugf("%s", jout)
 	fmt.Fprintf(w, "%s", jout)
@@ -138,16 +276,173 @@ func setLogger() {
 	}
 }
 
-// parseRequest extracts an AdmissionReview from an http.Request if possible
-func parseRequest(r http.Request) (*admissionv1.AdmissionReview, error) {
+// setConfig loads the webhook policy from the config file at path, the
+// default policy is used if empty
+func setConfig(path string) {
+	if path == "" {
+		logrus.Print("config file not set, using default policy")
+	}
+
+	var err error
+	policy, err = reload.NewConfig(path, buildPolicy)
+	if err != nil {
+		logrus.Fatalf("invalid config %q: %v", path, err)
+	}
+
+	logrus.WithField("config", path).Print("policy loaded")
+}
+
+// buildPolicy builds the mutator and validator chains of a loaded config, the
+// namespace cache is started the first time a config scoping rules by
+// namespace selector is loaded. Configs are loaded on startup and then
+// reloaded one at a time.
+func buildPolicy(cfg *config.Config) (*admission.Policy, error) {
+	p, err := admission.NewPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.NamespaceSelectors() && namespaceLabeler() == nil {
+		if err := setNamespaces(); err != nil {
+			return nil, fmt.Errorf("could not cache namespaces: %v", err)
+		}
+	}
+
+	return p, nil
+}
+
+// setNamespaces starts caching namespaces using the in-cluster service
+// account, rules scoped by namespace selector fail when not running in a
+// cluster
+func setNamespaces() error {
+	client, err := kubeClient()
+	if err != nil {
+		logrus.Warnf("not running in a cluster, namespace selectors are unavailable: %v", err)
+		return nil
+	}
+
+	cache, err := namespace.NewCache(context.Background(), client, namespaceSyncTimeout)
+	if err != nil {
+		return err
+	}
+
+	namespaces.Store(cache)
+	return nil
+}
+
+// namespaceLabeler returns the namespace cache, nil if it is not started
+func namespaceLabeler() admission.NamespaceLabeler {
+	if cache, ok := namespaces.Load().(*namespace.Cache); ok {
+		return cache
+	}
+	return nil
+}
+
+// kubeClient returns a kubernetes client using the in-cluster service account
+func kubeClient() (kubernetes.Interface, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not create kubernetes client: %v", err)
+	}
+	return client, nil
+}
+
+// bootstrapCerts generates or loads the webhook certificates from the
+// configured Secret, renewing them when they expire soon, and patches the CA
+// into the configured webhook configurations
+func bootstrapCerts(cfg *server.Config) (*certs.Bundle, error) {
+	client, err := kubeClient()
+	if err != nil {
+		return nil, fmt.Errorf("certificates can only be bootstrapped in a cluster: %v", err)
+	}
+
+	bundle, err := certs.Bootstrap(context.Background(), logrus.StandardLogger(), client, certs.Options{
+		Namespace:          cfg.Namespace,
+		SecretName:         cfg.CertSecret,
+		DNSNames:           cfg.DNSNames(),
+		MutatingWebhooks:   cfg.WebhookConfigs,
+		ValidatingWebhooks: cfg.WebhookConfigs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not bootstrap certificates: %v", err)
+	}
+	return bundle, nil
+}
+
+// renewCerts bootstraps the certificates again every certRenewInterval so
+// that they are renewed in process, without restarting, before they expire.
+// Changed certificates are written to the configured files, which the
+// certificate reloader picks up.
+func renewCerts(cfg *server.Config, current *certs.Bundle) {
+	for range time.Tick(certRenewInterval) {
+		bundle, err := bootstrapCerts(cfg)
+		if err != nil {
+			logrus.Errorf("could not renew certificates: %v", err)
+			continue
+		}
+		if bytes.Equal(bundle.Cert, current.Cert) {
+			continue
+		}
+
+		if err := bundle.WriteFiles(cfg.CertFile, cfg.KeyFile); err != nil {
+			logrus.Errorf("could not write renewed certificates: %v", err)
+			continue
+		}
+		logrus.Info("certificates renewed")
+		current = bundle
+	}
+}
+
+// watchFiles reloads the policy config, along with any given reloaders,
+// whenever one of the given files changes. Failed reloads are logged and the
+// previously loaded version is kept.
+func watchFiles(files []string, reloaders ...func() error) {
+	var paths []string
+	for _, f := range files {
+		if f != "" {
+			paths = append(paths, f)
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	reloaders = append(reloaders, policy.Reload)
+	err := reload.Watch(context.Background(), logrus.StandardLogger(), paths, func() {
+		for _, r := range reloaders {
+			if err := r(); err != nil {
+				logrus.Errorf("reload failed: %v", err)
+			}
+		}
+	})
+	if err != nil {
+		logrus.Fatalf("could not watch files: %v", err)
+	}
+}
+
+// parseRequest extracts an AdmissionReview from an http.Request if possible,
+// bodies over maxBytes are rejected with errRequestTooLarge
+func parseRequest(r *http.Request, maxBytes int64) (*admissionv1.AdmissionReview, error) {
 	if r.Header.Get("Content-Type") != "application/json" {
 		return nil, fmt.Errorf("Content-Type: %q should be %q",
 			r.Header.Get("Content-Type"), "application/json")
 	}
 
-	bodybuf := new(bytes.Buffer)
-	bodybuf.ReadFrom(r.Body)
-	body := bodybuf.Bytes()
+	if r.ContentLength > maxBytes {
+		return nil, errRequestTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not read admission request body: %v", err)
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, errRequestTooLarge
+	}
 
 	if len(body) == 0 {
 		return nil, fmt.Errorf("admission request body is empty")
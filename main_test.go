@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/admission"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/metrics"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/reload"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/server"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// setup sets the default policy and the given server config
+func setup(t *testing.T, cfg *server.Config) {
+	p, err := reload.NewConfig("", buildPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, serverConfig = p, cfg
+}
+
+// reviewBody returns an admission review creating a pod
+func reviewBody(t *testing.T) []byte {
+	pod, err := json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test"),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Operation: admissionv1.Create,
+			Namespace: "default",
+			Object:    runtime.RawExtension{Raw: pod},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// post posts body to handler and returns the response
+func post(handler http.HandlerFunc, body []byte, contentLength int64) *http.Response {
+	r := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.ContentLength = contentLength
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Result()
+}
+
+func TestServeValidate(t *testing.T) {
+	setup(t, &server.Config{MaxRequestBytes: server.DefaultMaxRequestBytes, AdmissionTimeout: time.Second})
+	body := reviewBody(t)
+
+	resp := post(ServeValidate, body, int64(len(body)))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, types.UID("test"), review.Response.UID)
+	assert.True(t, review.Response.Allowed)
+}
+
+func TestServeReviewTooLarge(t *testing.T) {
+	body := reviewBody(t)
+	setup(t, &server.Config{MaxRequestBytes: int64(len(body)) - 1, AdmissionTimeout: time.Second})
+
+	tests := []struct {
+		name          string
+		contentLength int64
+	}{
+		{name: "content length", contentLength: int64(len(body))},
+		// chunked bodies are only cut off once read
+		{name: "unknown content length", contentLength: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(ServeValidate, body, tt.contentLength)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+			msg, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "admission request body is too large\n", string(msg))
+		})
+	}
+}
+
+func TestServeReviewTimeout(t *testing.T) {
+	setup(t, &server.Config{MaxRequestBytes: server.DefaultMaxRequestBytes, AdmissionTimeout: 10 * time.Millisecond})
+	body := reviewBody(t)
+	timeouts := metrics.Requests.WithLabelValues("slow", "CREATE", "default", metrics.OutcomeTimeout)
+	before := testutil.ToFloat64(timeouts)
+
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		serveReview(w, r, "slow", func(_ admission.Admitter, ctx context.Context) (*admissionv1.AdmissionReview, error) {
+			<-ctx.Done()
+			return nil, fmt.Errorf("validation %q did not complete: %w", "slow", ctx.Err())
+		})
+	}
+	resp := post(slow, body, int64(len(body)))
+
+	// timeouts are answered with a denying review rather than an error, so
+	// that the webhook failure policy doesn't apply
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, types.UID("test"), review.Response.UID)
+	assert.False(t, review.Response.Allowed)
+	assert.Equal(t, int32(http.StatusGatewayTimeout), review.Response.Result.Code)
+	assert.Equal(t, `admission request timed out after 10ms: validation "slow" did not complete: context deadline exceeded`,
+		review.Response.Result.Message)
+	assert.Equal(t, before+1, testutil.ToFloat64(timeouts))
+}
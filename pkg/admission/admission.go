@@ -4,81 +4,455 @@
 package admission
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/lifespan"
 	"github.com/slackhq/simple-kubernetes-webhook/pkg/mutation"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
 	"github.com/slackhq/simple-kubernetes-webhook/pkg/validation"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	types "k8s.io/apimachinery/pkg/types"
 )
 
+// mutationsAuditAnnotation is the audit annotation listing applied mutations,
+// the API server prefixes it with the webhook name in audit logs
+const mutationsAuditAnnotation = "mutations"
+
+// ephemeralContainersSubResource is the pod subresource adding ephemeral
+// containers, API servers from 1.23 send it as a pod
+const ephemeralContainersSubResource = "ephemeralcontainers"
+
+var (
+	podKind     = metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"}
+	serviceKind = metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Service"}
+)
+
 // Admitter is a container for admission business
 type Admitter struct {
 	Logger  *logrus.Entry
 	Request *admissionv1.AdmissionRequest
+	// Policy holds the mutator and validator chains applied, the default
+	// policy is applied if nil
+	Policy *Policy
+	// Namespaces looks up namespace labels for rules scoped by namespace
+	// selector, it is optional
+	Namespaces NamespaceLabeler
+}
+
+// NamespaceLabeler looks up the labels of a namespace by name
+type NamespaceLabeler interface {
+	Labels(name string) (map[string]string, error)
+}
+
+// Policy is a webhook config along with the mutator and validator chains it
+// enables, they are built once when the config is loaded and shared by all
+// admission requests
+type Policy struct {
+	Config    *config.Config
+	Mutator   *mutation.Mutator
+	Validator *validation.Validator
+}
+
+// defaultPolicy is applied by admitters without a policy
+var defaultPolicy = mustNewPolicy(config.Default())
+
+// NewPolicy builds the mutator and validator chains enabled by cfg, it
+// returns an error if cfg enables unknown mutators or validators, configures
+// them with invalid params, or configures the lifespan rules with different
+// families
+func NewPolicy(cfg *config.Config) (*Policy, error) {
+	logger := logrus.NewEntry(logrus.StandardLogger())
+	if err := lifespan.CheckShared(cfg); err != nil {
+		return nil, err
+	}
+
+	m, err := mutation.NewMutator(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	v, err := validation.NewValidator(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Policy{Config: cfg, Mutator: m, Validator: v}, nil
+}
+
+// NamespaceSelectors returns true if a rule, or a scope within its params, is
+// scoped by namespace selector, namespace labels then have to be looked up
+func (p *Policy) NamespaceSelectors() bool {
+	return p.Mutator.NamespaceSelectors() || p.Validator.NamespaceSelectors()
+}
+
+// mustNewPolicy is like NewPolicy but panics if cfg is invalid
+func mustNewPolicy(cfg *config.Config) *Policy {
+	p, err := NewPolicy(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// MutateReview takes an admission request and mutates the object within
+// according to its kind, it returns an admission review with mutations as a
+// json patch (if any). Workloads have their pod template mutated. Enforced
+// mutators still running once ctx is done fail the review with an error
+// wrapping ctx.Err().
+func (a Admitter) MutateReview(ctx context.Context) (*admissionv1.AdmissionReview, error) {
+	if a.Request.SubResource != "" {
+		return reviewResponse(a.Request.UID, true, http.StatusAccepted, "subresources are not mutated"), nil
+	}
+
+	switch a.Request.Kind {
+	case podKind:
+		return a.MutatePodReview(ctx)
+	case serviceKind:
+		return reviewResponse(a.Request.UID, true, http.StatusAccepted, "no mutations for services"), nil
+	}
+
+	if newWorkload, ok := workloads[a.Request.Kind]; ok {
+		return a.mutateWorkloadReview(ctx, newWorkload)
+	}
+
+	return a.unsupportedReview()
+}
+
+// ValidateReview takes an admission request and validates the object within
+// according to its kind, it returns an admission review. Workloads have their
+// pod template validated. The ephemeralcontainers subresource of pods is
+// validated as a pod update, so that containers added to running pods are
+// validated too. Enforced validators still running once ctx is done fail the
+// review with an error wrapping ctx.Err().
+func (a Admitter) ValidateReview(ctx context.Context) (*admissionv1.AdmissionReview, error) {
+	ephemeral := a.Request.Kind == podKind && a.Request.SubResource == ephemeralContainersSubResource
+	if a.Request.SubResource != "" && !ephemeral {
+		return reviewResponse(a.Request.UID, true, http.StatusAccepted, "subresources are not validated"), nil
+	}
+
+	switch a.Request.Kind {
+	case podKind:
+		return a.ValidatePodReview(ctx)
+	case serviceKind:
+		return a.validateServiceReview(ctx)
+	}
+
+	if newWorkload, ok := workloads[a.Request.Kind]; ok {
+		return a.validateWorkloadReview(ctx, newWorkload)
+	}
+
+	return a.unsupportedReview()
 }
 
 // MutatePodReview takes an admission request and mutates the pod within,
 // it returns an admission review with mutations as a json patch (if any)
-func (a Admitter) MutatePodReview() (*admissionv1.AdmissionReview, error) {
+func (a Admitter) MutatePodReview(ctx context.Context) (*admissionv1.AdmissionReview, error) {
+	if review := a.unmutableReview(); review != nil {
+		return review, nil
+	}
+
 	pod, err := a.Pod()
 	if err != nil {
 		e := fmt.Sprintf("could not parse pod in admission review request: %v", err)
 		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
 	}
 
-	m := mutation.NewMutator(a.Logger)
-	patch, err := m.MutatePodPatch(pod)
+	old, err := a.oldObject(&corev1.Pod{})
 	if err != nil {
-		e := fmt.Sprintf("could not mutate pod: %v", err)
+		e := fmt.Sprintf("could not parse old pod in admission review request: %v", err)
 		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
 	}
 
-	return patchReviewResponse(a.Request.UID, patch)
+	res, review, err := a.mutatePod(ctx, a.attributes(old, false), pod)
+	if review != nil {
+		return review, err
+	}
+
+	patch, err := mutation.CreatePatch(pod, res.Pod)
+	if err != nil {
+		e := fmt.Sprintf("could not create patch: %v", err)
+		return reviewResponse(a.Request.UID, false, http.StatusInternalServerError, e), err
+	}
+
+	return mutationReviewResponse(a.Request.UID, patch, res)
 }
 
-// MutatePodReview takes an admission request and validates the pod within
-// it returns an admission review
-func (a Admitter) ValidatePodReview() (*admissionv1.AdmissionReview, error) {
+// ValidatePodReview takes an admission request and validates the pod within
+// it returns an admission review. On DELETE the pod being removed is
+// validated.
+func (a Admitter) ValidatePodReview(ctx context.Context) (*admissionv1.AdmissionReview, error) {
+	if a.Request.Operation == admissionv1.Connect {
+		return reviewResponse(a.Request.UID, true, http.StatusAccepted, "connect requests are not validated"), nil
+	}
+
 	pod, err := a.Pod()
 	if err != nil {
 		e := fmt.Sprintf("could not parse pod in admission review request: %v", err)
 		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
 	}
 
-	v := validation.NewValidator(a.Logger)
-	val, err := v.ValidatePod(pod)
+	old, err := a.oldObject(&corev1.Pod{})
+	if err != nil {
+		e := fmt.Sprintf("could not parse old pod in admission review request: %v", err)
+		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	return a.validatePod(ctx, a.attributes(old, false), pod)
+}
+
+// mutateWorkloadReview mutates the pod template of the workload within the
+// admission request, it returns an admission review with mutations as a json
+// patch (if any)
+func (a Admitter) mutateWorkloadReview(ctx context.Context, newWorkload newWorkload) (*admissionv1.AdmissionReview, error) {
+	if review := a.unmutableReview(); review != nil {
+		return review, nil
+	}
+
+	obj, tmpl := newWorkload()
+	if err := json.Unmarshal(a.object(), obj); err != nil {
+		e := fmt.Sprintf("could not parse %s in admission review request: %v", a.Request.Kind.Kind, err)
+		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	old, err := a.oldTemplatePod(newWorkload)
+	if err != nil {
+		e := fmt.Sprintf("could not parse old %s in admission review request: %v", a.Request.Kind.Kind, err)
+		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	original := obj.DeepCopyObject()
+	pod := templatePod(tmpl, a.Request.Name, a.Request.Namespace)
+
+	res, review, err := a.mutatePod(ctx, a.attributes(old, true), pod)
+	if review != nil {
+		return review, err
+	}
+	setTemplate(tmpl, res.Pod)
+
+	patch, err := mutation.CreatePatch(original, obj)
+	if err != nil {
+		e := fmt.Sprintf("could not create patch: %v", err)
+		return reviewResponse(a.Request.UID, false, http.StatusInternalServerError, e), err
+	}
+
+	return mutationReviewResponse(a.Request.UID, patch, res)
+}
+
+// validateWorkloadReview validates the pod template of the workload within
+// the admission request, it returns an admission review
+func (a Admitter) validateWorkloadReview(ctx context.Context, newWorkload newWorkload) (*admissionv1.AdmissionReview, error) {
+	if a.Request.Operation == admissionv1.Connect {
+		return reviewResponse(a.Request.UID, true, http.StatusAccepted, "connect requests are not validated"), nil
+	}
+
+	obj, tmpl := newWorkload()
+	if err := json.Unmarshal(a.object(), obj); err != nil {
+		e := fmt.Sprintf("could not parse %s in admission review request: %v", a.Request.Kind.Kind, err)
+		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	old, err := a.oldTemplatePod(newWorkload)
+	if err != nil {
+		e := fmt.Sprintf("could not parse old %s in admission review request: %v", a.Request.Kind.Kind, err)
+		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	pod := templatePod(tmpl, a.Request.Name, a.Request.Namespace)
+	return a.validatePod(ctx, a.attributes(old, true), pod)
+}
+
+// validateServiceReview validates the service within the admission request,
+// it returns an admission review
+func (a Admitter) validateServiceReview(ctx context.Context) (*admissionv1.AdmissionReview, error) {
+	if a.Request.Operation == admissionv1.Connect {
+		return reviewResponse(a.Request.UID, true, http.StatusAccepted, "connect requests are not validated"), nil
+	}
+
+	svc := &corev1.Service{}
+	if err := json.Unmarshal(a.object(), svc); err != nil {
+		e := fmt.Sprintf("could not parse service in admission review request: %v", err)
+		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	old, err := a.oldObject(&corev1.Service{})
+	if err != nil {
+		e := fmt.Sprintf("could not parse old service in admission review request: %v", err)
+		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	v := a.policy().Validator.WithLogger(a.Logger.WithField("service_name", svc.Name))
+	val, err := v.ValidateService(ctx, a.attributes(old, false), svc)
+	if err != nil {
+		e := fmt.Sprintf("could not validate service: %v", err)
+		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	review := reviewResponse(a.Request.UID, true, http.StatusAccepted, "valid service")
+	if !val.Valid {
+		review = deniedReviewResponse(a.Request.UID, val.Reason, val.Failures)
+	}
+	review.Response.Warnings = val.Warnings
+
+	return review, nil
+}
+
+// mutatePod applies all configured mutations to a copy of the given pod, a
+// review denying the request is returned instead if the pod can't be mutated
+func (a Admitter) mutatePod(ctx context.Context, req *request.Request, pod *corev1.Pod) (mutation.Result, *admissionv1.AdmissionReview, error) {
+	m := a.policy().Mutator.WithLogger(podLogger(a.Logger, pod))
+	res, err := m.MutatePod(ctx, req, pod)
+	if err != nil {
+		e := fmt.Sprintf("could not mutate pod: %v", err)
+		return mutation.Result{}, reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
+	}
+
+	return res, nil, nil
+}
+
+// validatePod validates the given pod against all configured validations, it
+// returns an admission review
+func (a Admitter) validatePod(ctx context.Context, req *request.Request, pod *corev1.Pod) (*admissionv1.AdmissionReview, error) {
+	v := a.policy().Validator.WithLogger(podLogger(a.Logger, pod))
+	val, err := v.ValidatePod(ctx, req, pod)
 	if err != nil {
 		e := fmt.Sprintf("could not validate pod: %v", err)
 		return reviewResponse(a.Request.UID, false, http.StatusBadRequest, e), err
 	}
 
+	review := reviewResponse(a.Request.UID, true, http.StatusAccepted, "valid pod")
 	if !val.Valid {
-		return reviewResponse(a.Request.UID, false, http.StatusForbidden, val.Reason), nil
+		review = deniedReviewResponse(a.Request.UID, val.Reason, val.Failures)
+	}
+	review.Response.Warnings = val.Warnings
+
+	return review, nil
+}
+
+// unmutableReview returns a review admitting the request untouched for
+// operations that can't be mutated, that is DELETE and CONNECT, nil otherwise
+func (a Admitter) unmutableReview() *admissionv1.AdmissionReview {
+	switch a.Request.Operation {
+	case admissionv1.Delete, admissionv1.Connect:
+		e := fmt.Sprintf("nothing to mutate on %s", a.Request.Operation)
+		return reviewResponse(a.Request.UID, true, http.StatusAccepted, e)
+	}
+	return nil
+}
+
+// unsupportedReview returns a review denying requests for unsupported kinds,
+// CONNECT requests are admitted as they don't carry the object they connect
+// to
+func (a Admitter) unsupportedReview() (*admissionv1.AdmissionReview, error) {
+	if a.Request.Operation == admissionv1.Connect {
+		return reviewResponse(a.Request.UID, true, http.StatusAccepted, "connect requests are not evaluated"), nil
+	}
+
+	err := fmt.Errorf("kind %s is not supported", a.Request.Kind.String())
+	return reviewResponse(a.Request.UID, false, http.StatusBadRequest, err.Error()), err
+}
+
+// attributes returns the request attributes passed down to mutators and
+// validators, along with the object being replaced or removed if any.
+// Namespace labels are left unset if they can't be looked up, rules scoped by
+// namespace selector then fail.
+func (a Admitter) attributes(old runtime.Object, template bool) *request.Request {
+	req := &request.Request{
+		Operation: a.Request.Operation,
+		Namespace: a.Request.Namespace,
+		UserInfo:  a.Request.UserInfo,
+		DryRun:    a.Request.DryRun != nil && *a.Request.DryRun,
+		OldObject: old,
+		Template:  template,
+	}
+
+	if a.Namespaces != nil && a.Request.Namespace != "" {
+		labels, err := a.Namespaces.Labels(a.Request.Namespace)
+		if err != nil {
+			a.Logger.Warnf("could not look up namespace labels: %v", err)
+		}
+		req.NamespaceLabels = labels
 	}
 
-	return reviewResponse(a.Request.UID, true, http.StatusAccepted, "valid pod"), nil
+	return req
+}
+
+// object returns the raw object under admission, on DELETE that is the
+// object being removed
+func (a Admitter) object() []byte {
+	if a.Request.Operation == admissionv1.Delete {
+		return a.Request.OldObject.Raw
+	}
+	return a.Request.Object.Raw
 }
 
-// Pod extracts a pod from an admission request
+// oldObject parses the object being replaced or removed into obj, it returns
+// nil if the request has none
+func (a Admitter) oldObject(obj runtime.Object) (runtime.Object, error) {
+	if len(a.Request.OldObject.Raw) == 0 {
+		return nil, nil
+	}
+
+	if err := json.Unmarshal(a.Request.OldObject.Raw, obj); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// oldTemplatePod returns the pod templated by the workload being replaced or
+// removed, nil if the request has none
+func (a Admitter) oldTemplatePod(newWorkload newWorkload) (runtime.Object, error) {
+	obj, tmpl := newWorkload()
+	old, err := a.oldObject(obj)
+	if old == nil || err != nil {
+		return nil, err
+	}
+
+	return templatePod(tmpl, a.Request.Name, a.Request.Namespace), nil
+}
+
+// Pod extracts a pod from an admission request, on DELETE that is the pod
+// being removed
 func (a Admitter) Pod() (*corev1.Pod, error) {
 	if a.Request.Kind.Kind != "Pod" {
 		return nil, fmt.Errorf("only pods are supported here")
 	}
 
 	p := corev1.Pod{}
-	if err := json.Unmarshal(a.Request.Object.Raw, &p); err != nil {
+	if err := json.Unmarshal(a.object(), &p); err != nil {
 		return nil, err
 	}
 
 	return &p, nil
 }
 
+// policy returns the admitter policy, or the default policy if none is set
+func (a Admitter) policy() *Policy {
+	if a.Policy == nil {
+		return defaultPolicy
+	}
+	return a.Policy
+}
+
+// podLogger returns a logger annotated with the name of the given pod, falling
+// back to its generate name when the pod name is not yet known
+func podLogger(logger *logrus.Entry, pod *corev1.Pod) *logrus.Entry {
+	podName := pod.Name
+	if podName == "" {
+		podName = pod.GenerateName
+	}
+
+	return logger.WithField("pod_name", podName)
+}
+
 // reviewResponse TODO: godoc
 func reviewResponse(uid types.UID, allowed bool, httpCode int32,
 	reason string) *admissionv1.AdmissionReview {
@@ -98,6 +472,56 @@ func reviewResponse(uid types.UID, allowed bool, httpCode int32,
 	}
 }
 
+// TimeoutReview builds an admission review denying a request whose rules did
+// not complete in time with 504 Gateway Timeout
+func TimeoutReview(uid types.UID, reason string) *admissionv1.AdmissionReview {
+	review := reviewResponse(uid, false, http.StatusGatewayTimeout, reason)
+	review.Response.Result.Reason = metav1.StatusReasonTimeout
+	return review
+}
+
+// deniedReviewResponse builds an admission review denying a request, each
+// validation failure is listed as an invalid field value cause in the response
+// status details, its message prefixed by the validator name
+func deniedReviewResponse(uid types.UID, reason string,
+	failures []validation.Failure) *admissionv1.AdmissionReview {
+	review := reviewResponse(uid, false, http.StatusForbidden, reason)
+	if len(failures) == 0 {
+		return review
+	}
+
+	causes := make([]metav1.StatusCause, len(failures))
+	for i, f := range failures {
+		causes[i] = metav1.StatusCause{
+			Type:    metav1.CauseTypeFieldValueInvalid,
+			Message: f.Validator + ": " + f.Reason,
+		}
+	}
+	review.Response.Result.Reason = metav1.StatusReasonForbidden
+	review.Response.Result.Details = &metav1.StatusDetails{Causes: causes}
+
+	return review
+}
+
+// mutationReviewResponse builds an admission review with the given json patch,
+// applied mutations are recorded as an audit annotation
+func mutationReviewResponse(uid types.UID, patch []byte,
+	res mutation.Result) (*admissionv1.AdmissionReview, error) {
+	review, err := patchReviewResponse(uid, patch)
+	if err != nil {
+		return nil, err
+	}
+	review.Response.Warnings = res.Warnings
+
+	if len(res.Applied) > 0 {
+		review.Response.AuditAnnotations = map[string]string{
+			mutationsAuditAnnotation: strings.Join(res.Applied, ","),
+		}
+	}
+
+	return review, nil
+}
+
 // patchReviewResponse builds an admission review with given json patch
 func patchReviewResponse(uid types.UID, patch []byte) (*admissionv1.AdmissionReview, error) {
 	patchType := admissionv1.PatchTypeJSONPatch
@@ -1,12 +1,22 @@
 package admission
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"testing"
 
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/mutation"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/validation"
 	"github.com/stretchr/testify/assert"
 	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -96,3 +106,441 @@ func TestPatchReviewResponse(t *testing.T) {
 	}
 	assert.Equal(t, want, got)
 }
+
+func TestNewPolicy(t *testing.T) {
+	p, err := NewPolicy(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, config.Default(), p.Config)
+	assert.NotNil(t, p.Mutator)
+	assert.NotNil(t, p.Validator)
+
+	_, err = NewPolicy(&config.Config{Validators: []config.Rule{{Name: "nope"}}})
+	assert.Error(t, err)
+
+	_, err = NewPolicy(&config.Config{Mutators: []config.Rule{{Name: "image_mirror", Params: []byte(`{"mirrors": {"": "mirror"}}`)}}})
+	assert.Error(t, err)
+	_, err = NewPolicy(&config.Config{
+		Mutators:   []config.Rule{{Name: "min_lifespan", Params: []byte(`{"families": [{"labelKey": "a", "taintKey": "b"}]}`)}},
+		Validators: []config.Rule{{Name: "lifespan_label"}},
+	})
+	assert.EqualError(t, err, `"min_lifespan" and "lifespan_label" must be configured with the same lifespan families`)
+}
+
+func TestPolicyNamespaceSelectors(t *testing.T) {
+	assert.False(t, newPolicy(t, config.Default()).NamespaceSelectors())
+
+	for name, yaml := range map[string]string{
+		"rule scope": `
+validators:
+  - name: name_validator
+    scope:
+      namespaceSelector: {matchLabels: {tier: prod}}
+`,
+		// selectors only nested in params still need namespace labels
+		"default_resources scope": `
+mutators:
+  - name: default_resources
+    params:
+      defaults:
+        - scope:
+            namespaceSelector: {matchLabels: {tier: prod}}
+          requests: {cpu: 100m}
+`,
+		"resource_bounds scope": `
+validators:
+  - name: resource_bounds
+    params:
+      bounds:
+        - scope:
+            namespaceSelector: {matchLabels: {tier: prod}}
+          max: {cpu: "4"}
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := config.Parse([]byte(yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, newPolicy(t, cfg).NamespaceSelectors())
+		})
+	}
+
+	cfg, err := config.Parse([]byte(`
+mutators:
+  - name: default_resources
+    scope:
+      namespaces: [team-*]
+    params:
+      defaults:
+        - scope:
+            namespaces: [batch-*]
+          requests: {cpu: 100m}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, newPolicy(t, cfg).NamespaceSelectors())
+}
+
+func TestValidateReviewService(t *testing.T) {
+	cfg := &config.Config{Validators: []config.Rule{{Name: "service_type"}}}
+
+	for _, tc := range []struct {
+		name    string
+		svcType corev1.ServiceType
+		allowed bool
+	}{
+		{"cluster ip", corev1.ServiceTypeClusterIP, true},
+		{"load balancer", corev1.ServiceTypeLoadBalancer, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := json.Marshal(&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "svc"},
+				Spec:       corev1.ServiceSpec{Type: tc.svcType},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := Admitter{
+				Logger: logger(),
+				Policy: newPolicy(t, cfg),
+				Request: &admissionv1.AdmissionRequest{
+					UID:       types.UID("test"),
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+
+			got, err := a.ValidateReview(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.allowed, got.Response.Allowed)
+		})
+	}
+}
+
+func TestValidateReviewUnsupported(t *testing.T) {
+	a := Admitter{
+		Logger: logger(),
+		Request: &admissionv1.AdmissionRequest{
+			UID:  types.UID("test"),
+			Kind: metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		},
+	}
+
+	got, err := a.ValidateReview(context.Background())
+	assert.Error(t, err)
+	assert.False(t, got.Response.Allowed)
+}
+
+func TestValidateReviewConnect(t *testing.T) {
+	a := Admitter{
+		Logger: logger(),
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test"),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "PodExecOptions"},
+			Operation: admissionv1.Connect,
+		},
+	}
+
+	got, err := a.ValidateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, got.Response.Allowed)
+}
+
+func TestReviewPodDelete(t *testing.T) {
+	raw, err := json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "lifespan"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := Admitter{
+		Logger: logger(),
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test"),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Operation: admissionv1.Delete,
+			OldObject: runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	// the pod being removed is the one under admission
+	pod, err := a.Pod()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "lifespan", pod.Name)
+
+	got, err := a.MutateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, got.Response.Allowed)
+	assert.Nil(t, got.Response.Patch)
+
+	got, err = a.ValidateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, got.Response.Allowed)
+}
+
+func TestAttributes(t *testing.T) {
+	dryRun := true
+	a := Admitter{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			Namespace: "apps",
+			UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+			DryRun:    &dryRun,
+		},
+	}
+
+	old := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "lifespan"}}
+	want := &request.Request{
+		Operation: admissionv1.Update,
+		Namespace: "apps",
+		UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+		DryRun:    true,
+		OldObject: old,
+		Template:  true,
+	}
+	assert.Equal(t, want, a.attributes(old, true))
+
+	a.Request.DryRun = nil
+	assert.False(t, a.attributes(nil, false).DryRun)
+}
+
+// namespaceLabels is a NamespaceLabeler backed by a map
+type namespaceLabels map[string]map[string]string
+
+func (n namespaceLabels) Labels(name string) (map[string]string, error) {
+	l, ok := n[name]
+	if !ok {
+		return nil, fmt.Errorf("namespace %q not found", name)
+	}
+	return l, nil
+}
+
+func TestValidateReviewNamespaceSelector(t *testing.T) {
+	raw, err := json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "offensive"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Validators: []config.Rule{{
+		Name: "name_validator",
+		Scope: &config.Scope{NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"tier": "prod"},
+		}},
+	}}}
+	namespaces := namespaceLabels{
+		"prod": {"tier": "prod"},
+		"dev":  {"tier": "dev"},
+	}
+
+	for _, tc := range []struct {
+		namespace string
+		allowed   bool
+	}{
+		{"prod", false},
+		{"dev", true},
+	} {
+		t.Run(tc.namespace, func(t *testing.T) {
+			a := Admitter{
+				Logger:     logger(),
+				Policy:     newPolicy(t, cfg),
+				Namespaces: namespaces,
+				Request: &admissionv1.AdmissionRequest{
+					UID:       types.UID("test"),
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Operation: admissionv1.Create,
+					Namespace: tc.namespace,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+
+			got, err := a.ValidateReview(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.allowed, got.Response.Allowed)
+		})
+	}
+
+	t.Run("unknown namespace", func(t *testing.T) {
+		a := Admitter{
+			Logger:     logger(),
+			Policy:     newPolicy(t, cfg),
+			Namespaces: namespaces,
+			Request: &admissionv1.AdmissionRequest{
+				UID:       types.UID("test"),
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Operation: admissionv1.Create,
+				Namespace: "nope",
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		}
+
+		got, err := a.ValidateReview(context.Background())
+		assert.Error(t, err)
+		assert.False(t, got.Response.Allowed)
+	})
+}
+
+func TestMutateReviewSubresource(t *testing.T) {
+	a := Admitter{
+		Logger: logger(),
+		Request: &admissionv1.AdmissionRequest{
+			UID:         types.UID("test"),
+			Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			SubResource: "status",
+		},
+	}
+
+	got, err := a.MutateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, got.Response.Allowed)
+	assert.Nil(t, got.Response.Patch)
+}
+
+func TestValidateReviewEphemeralContainers(t *testing.T) {
+	old := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+	}
+	pod := old.DeepCopy()
+	pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"},
+	}}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRaw, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := Admitter{
+		Logger: logger(),
+		Policy: newPolicy(t, &config.Config{Validators: []config.Rule{{Name: "image_policy"}}}),
+		Request: &admissionv1.AdmissionRequest{
+			UID:         types.UID("test"),
+			Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			SubResource: "ephemeralcontainers",
+			Operation:   admissionv1.Update,
+			Object:      runtime.RawExtension{Raw: raw},
+			OldObject:   runtime.RawExtension{Raw: oldRaw},
+		},
+	}
+
+	got, err := a.ValidateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, got.Response.Allowed)
+	assert.Equal(t, `container "debugger" image "busybox" has no tag`, got.Response.Result.Message)
+}
+
+func TestReviewTimeout(t *testing.T) {
+	raw, err := json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a := Admitter{
+		Logger: logger(),
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test"),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	_, err = a.MutateReview(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	_, err = a.ValidateReview(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+// newPolicy returns the policy built from cfg
+func newPolicy(t *testing.T, cfg *config.Config) *Policy {
+	p, err := NewPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func logger() *logrus.Entry {
+	mute := logrus.StandardLogger()
+	mute.Out = ioutil.Discard
+	return mute.WithField("logger", "test")
+}
+
+func TestTimeoutReview(t *testing.T) {
+	got := TimeoutReview(types.UID("test"), "timed out")
+	assert.False(t, got.Response.Allowed)
+	assert.Equal(t, int32(http.StatusGatewayTimeout), got.Response.Result.Code)
+	assert.Equal(t, metav1.StatusReasonTimeout, got.Response.Result.Reason)
+	assert.Equal(t, "timed out", got.Response.Result.Message)
+}
+
+func TestDeniedReviewResponse(t *testing.T) {
+	uid := types.UID("test")
+	failures := []validation.Failure{
+		{Validator: "name_validator", Reason: "bad name"},
+		{Validator: "service_type", Reason: "bad type"},
+	}
+
+	got := deniedReviewResponse(uid, "2 policy violations", failures)
+
+	want := &metav1.Status{
+		Code:    http.StatusForbidden,
+		Message: "2 policy violations",
+		Reason:  metav1.StatusReasonForbidden,
+		Details: &metav1.StatusDetails{
+			Causes: []metav1.StatusCause{
+				{Type: metav1.CauseTypeFieldValueInvalid, Message: "name_validator: bad name"},
+				{Type: metav1.CauseTypeFieldValueInvalid, Message: "service_type: bad type"},
+			},
+		},
+	}
+	assert.False(t, got.Response.Allowed)
+	assert.Equal(t, want, got.Response.Result)
+}
+
+func TestMutationReviewResponse(t *testing.T) {
+	uid := types.UID("test")
+	patch := []byte(`[]`)
+
+	got, err := mutationReviewResponse(uid, patch, mutation.Result{
+		Applied:  []string{"min_lifespan", "inject_env"},
+		Warnings: []string{"careful"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]string{"mutations": "min_lifespan,inject_env"}, got.Response.AuditAnnotations)
+	assert.Equal(t, []string{"careful"}, got.Response.Warnings)
+
+	got, err = mutationReviewResponse(uid, patch, mutation.Result{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, got.Response.AuditAnnotations)
+}
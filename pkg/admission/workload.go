@@ -0,0 +1,72 @@
+package admission
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newWorkload returns an empty workload object along with a pointer to the pod
+// template embedded in it
+type newWorkload func() (runtime.Object, *corev1.PodTemplateSpec)
+
+// workloads lists the kinds embedding a pod template, pod mutations and
+// validations are applied to their template
+var workloads = map[metav1.GroupVersionKind]newWorkload{
+	{Group: "apps", Version: "v1", Kind: "Deployment"}: func() (runtime.Object, *corev1.PodTemplateSpec) {
+		o := &appsv1.Deployment{}
+		return o, &o.Spec.Template
+	},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"}: func() (runtime.Object, *corev1.PodTemplateSpec) {
+		o := &appsv1.StatefulSet{}
+		return o, &o.Spec.Template
+	},
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"}: func() (runtime.Object, *corev1.PodTemplateSpec) {
+		o := &appsv1.DaemonSet{}
+		return o, &o.Spec.Template
+	},
+	{Group: "apps", Version: "v1", Kind: "ReplicaSet"}: func() (runtime.Object, *corev1.PodTemplateSpec) {
+		o := &appsv1.ReplicaSet{}
+		return o, &o.Spec.Template
+	},
+	{Group: "batch", Version: "v1", Kind: "Job"}: func() (runtime.Object, *corev1.PodTemplateSpec) {
+		o := &batchv1.Job{}
+		return o, &o.Spec.Template
+	},
+	{Group: "batch", Version: "v1", Kind: "CronJob"}: func() (runtime.Object, *corev1.PodTemplateSpec) {
+		o := &batchv1.CronJob{}
+		return o, &o.Spec.JobTemplate.Spec.Template
+	},
+	{Group: "batch", Version: "v1beta1", Kind: "CronJob"}: func() (runtime.Object, *corev1.PodTemplateSpec) {
+		o := &batchv1beta1.CronJob{}
+		return o, &o.Spec.JobTemplate.Spec.Template
+	},
+}
+
+// templatePod returns the pod a workload controller would create from the
+// given template. The workload name is used as the pod generate name, as
+// controllers do.
+func templatePod(tmpl *corev1.PodTemplateSpec, name, namespace string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: *tmpl.ObjectMeta.DeepCopy(),
+		Spec:       *tmpl.Spec.DeepCopy(),
+	}
+
+	pod.Namespace = namespace
+	if pod.Name == "" && pod.GenerateName == "" && name != "" {
+		pod.GenerateName = name + "-"
+	}
+
+	return pod
+}
+
+// setTemplate writes the labels, annotations and spec of a mutated pod back
+// into a pod template
+func setTemplate(tmpl *corev1.PodTemplateSpec, pod *corev1.Pod) {
+	tmpl.Labels = pod.Labels
+	tmpl.Annotations = pod.Annotations
+	tmpl.Spec = pod.Spec
+}
@@ -0,0 +1,152 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestTemplatePod(t *testing.T) {
+	tmpl := &corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": "deploy"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "deploy", Image: "busybox"}},
+		},
+	}
+
+	want := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "deploy-",
+			Namespace:    "apps",
+			Labels:       map[string]string{"app": "deploy"},
+		},
+		Spec: tmpl.Spec,
+	}
+
+	got := templatePod(tmpl, "deploy", "apps")
+	assert.Equal(t, want, got)
+
+	// the template is left untouched
+	got.Labels["foo"] = "bar"
+	assert.Equal(t, map[string]string{"app": "deploy"}, tmpl.Labels)
+}
+
+func TestSetTemplate(t *testing.T) {
+	tmpl := &corev1.PodTemplateSpec{}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "deploy-",
+			Namespace:    "apps",
+			Labels:       map[string]string{"app": "deploy"},
+			Annotations:  map[string]string{"foo": "bar"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "deploy", Image: "busybox"}},
+		},
+	}
+
+	setTemplate(tmpl, pod)
+
+	want := &corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app": "deploy"},
+			Annotations: map[string]string{"foo": "bar"},
+		},
+		Spec: pod.Spec,
+	}
+	assert.Equal(t, want, tmpl)
+}
+
+func TestMutateReviewDeployment(t *testing.T) {
+	a := Admitter{Logger: logger(), Request: deploymentRequest(t, "deploy")}
+
+	got, err := a.MutateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, got.Response.Allowed)
+	assert.Contains(t, string(got.Response.Patch), `"path":"/spec/template/spec/tolerations"`)
+	assert.Contains(t, string(got.Response.Patch), `"path":"/spec/template/spec/containers/0/env"`)
+}
+
+func TestMutateReviewDeploymentUpdate(t *testing.T) {
+	req := deploymentRequest(t, "deploy")
+	req.Operation = admissionv1.Update
+	req.OldObject = req.Object
+
+	a := Admitter{Logger: logger(), Request: req}
+
+	// templates only affect new pods so they are mutated on update as well
+	got, err := a.MutateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, got.Response.Allowed)
+	assert.Contains(t, string(got.Response.Patch), `"path":"/spec/template/spec/tolerations"`)
+}
+
+func TestValidateReviewDeployment(t *testing.T) {
+	a := Admitter{Logger: logger(), Request: deploymentRequest(t, "deploy")}
+
+	got, err := a.ValidateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, got.Response.Allowed)
+}
+
+func TestValidateReviewDeploymentInvalidName(t *testing.T) {
+	a := Admitter{Logger: logger(), Request: deploymentRequest(t, "offensive-deploy")}
+
+	// the template pod is validated under the generate name of its pods
+	got, err := a.ValidateReview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, got.Response.Allowed)
+	assert.Equal(t, `pod generate name contains "offensive"`, got.Response.Result.Message)
+}
+
+func deploymentRequest(t *testing.T, name string) *admissionv1.AdmissionRequest {
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "apps",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"app": "deploy"},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "deploy", Image: "busybox"}},
+				},
+			},
+		},
+	}
+
+	raw, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &admissionv1.AdmissionRequest{
+		UID:       types.UID("test"),
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Operation: admissionv1.Create,
+		Name:      name,
+		Namespace: "apps",
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
@@ -0,0 +1,537 @@
+// Package certs bootstraps the webhook TLS certificates: a self-signed CA and
+// a serving certificate are generated, persisted to a Secret shared by all
+// replicas, and the CA is patched into the caBundle of the webhook
+// configurations so that the API server trusts the webhook
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Keys of the CA in the Secret, the serving certificate is stored under the
+// usual tls.crt and tls.key. The previous CA certificate is kept after the CA
+// is replaced, until it expires.
+const (
+	CACertKey         = "ca.crt"
+	CAKeyKey          = "ca.key"
+	PreviousCACertKey = "ca-previous.crt"
+)
+
+// Defaults of the certificate lifetimes
+const (
+	DefaultCAValidity  = 10 * 365 * 24 * time.Hour
+	DefaultValidity    = 365 * 24 * time.Hour
+	DefaultRenewBefore = 30 * 24 * time.Hour
+)
+
+// Options configures the certificate bootstrap
+type Options struct {
+	// Namespace and SecretName locate the Secret the certificates are
+	// persisted to
+	Namespace  string
+	SecretName string
+	// DNSNames are the names the serving certificate is valid for, the first
+	// one is its common name
+	DNSNames []string
+	// MutatingWebhooks and ValidatingWebhooks are the names of the webhook
+	// configurations whose caBundle is patched, missing ones are skipped
+	MutatingWebhooks   []string
+	ValidatingWebhooks []string
+	// CAValidity and Validity are the lifetimes of new CA and serving
+	// certificates, DefaultCAValidity and DefaultValidity if unset
+	CAValidity time.Duration
+	Validity   time.Duration
+	// RenewBefore is how long before expiry certificates are replaced,
+	// DefaultRenewBefore if unset
+	RenewBefore time.Duration
+	// Now returns the current time, time.Now if unset
+	Now func() time.Time
+}
+
+// Bundle holds PEM encoded certificates and keys
+type Bundle struct {
+	CACert []byte
+	CAKey  []byte
+	Cert   []byte
+	Key    []byte
+	// PreviousCACert is the replaced CA certificate while it is valid, nil
+	// otherwise
+	PreviousCACert []byte
+}
+
+// CABundle returns the CA certificates the API server has to trust: the CA
+// along with the previous CA while it is valid, so that replicas still
+// serving a certificate signed by the previous CA remain trusted until they
+// load the new one
+func (b *Bundle) CABundle() []byte {
+	caBundle := append([]byte{}, b.CACert...)
+	return append(caBundle, b.PreviousCACert...)
+}
+
+// Bootstrap returns the certificates persisted to the Secret, replacing them
+// if they are missing, don't cover the DNS names or expire within
+// RenewBefore, and patches the CA bundle into the webhook configurations. A
+// replaced CA stays in the CA bundle until it expires, so that replicas still
+// serving a certificate it signed remain trusted.
+func Bootstrap(ctx context.Context, logger logrus.FieldLogger, client kubernetes.Interface, opts Options) (*Bundle, error) {
+	opts.setDefaults()
+	if len(opts.DNSNames) == 0 {
+		return nil, fmt.Errorf("no DNS name for the serving certificate")
+	}
+
+	secrets := client.CoreV1().Secrets(opts.Namespace)
+	secret, err := secrets.Get(ctx, opts.SecretName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = nil
+	case err != nil:
+		return nil, fmt.Errorf("could not get secret %s/%s: %v", opts.Namespace, opts.SecretName, err)
+	}
+
+	var current *Bundle
+	if secret != nil {
+		current = secretBundle(secret)
+	}
+
+	bundle, changed, err := renew(logger, current, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		bundle, err = persist(ctx, logger, client, secret, bundle, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := patchCABundles(ctx, logger, client, bundle.CABundle(), opts); err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
+
+// setDefaults sets the defaults of unset options
+func (o *Options) setDefaults() {
+	if o.CAValidity == 0 {
+		o.CAValidity = DefaultCAValidity
+	}
+	if o.Validity == 0 {
+		o.Validity = DefaultValidity
+	}
+	if o.RenewBefore == 0 {
+		o.RenewBefore = DefaultRenewBefore
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+}
+
+// renew returns the given bundle if it is still valid, or a bundle with a new
+// serving certificate, and a new CA if needed. A CA replaced before it
+// expires becomes the previous CA, which is dropped once expired. It returns
+// true if the bundle changed.
+func renew(logger logrus.FieldLogger, current *Bundle, opts Options) (*Bundle, bool, error) {
+	now := opts.Now()
+	renewAt := now.Add(opts.RenewBefore)
+
+	b := &Bundle{}
+	var ca *x509.Certificate
+	var caKey *ecdsa.PrivateKey
+	if current != nil {
+		var err error
+		ca, caKey, err = parsePair(current.CACert, current.CAKey)
+		switch {
+		case err != nil:
+			logger.Warnf("replacing CA: %v", err)
+		case ca.NotAfter.Before(renewAt):
+			logger.Infof("replacing CA expiring on %s", ca.NotAfter)
+			b.PreviousCACert = validCert(current.CACert, now)
+			ca = nil
+		default:
+			b.PreviousCACert = validCert(current.PreviousCACert, now)
+		}
+	}
+
+	if ca != nil {
+		b.CACert, b.CAKey = current.CACert, current.CAKey
+		cert, err := parseCert(current.Cert, current.Key)
+		switch {
+		case err != nil:
+			logger.Warnf("replacing serving certificate: %v", err)
+		case cert.NotAfter.Before(renewAt):
+			logger.Infof("replacing serving certificate expiring on %s", cert.NotAfter)
+		case cert.CheckSignatureFrom(ca) != nil:
+			logger.Info("replacing serving certificate not signed by the CA")
+		case !covers(cert, opts.DNSNames):
+			logger.Infof("replacing serving certificate not valid for %v", opts.DNSNames)
+		case !bytes.Equal(b.PreviousCACert, current.PreviousCACert):
+			logger.Info("dropping expired previous CA")
+			b.Cert, b.Key = current.Cert, current.Key
+			return b, true, nil
+		default:
+			return current, false, nil
+		}
+	} else {
+		var err error
+		ca, caKey, err = newCA(opts.DNSNames[0], now, opts.CAValidity)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not generate CA: %v", err)
+		}
+		b.CACert = encodeCert(ca.Raw)
+		if b.CAKey, err = encodeKey(caKey); err != nil {
+			return nil, false, err
+		}
+	}
+
+	cert, key, err := newServingCert(ca, caKey, opts.DNSNames, now, opts.Validity)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not generate serving certificate: %v", err)
+	}
+	b.Cert = encodeCert(cert)
+	if b.Key, err = encodeKey(key); err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
+// persist writes the bundle to the Secret, creating it if it doesn't exist.
+// If another replica created the Secret first its bundle is returned instead.
+func persist(ctx context.Context, logger logrus.FieldLogger, client kubernetes.Interface, secret *corev1.Secret, b *Bundle, opts Options) (*Bundle, error) {
+	secrets := client.CoreV1().Secrets(opts.Namespace)
+	data := map[string][]byte{
+		CACertKey:               b.CACert,
+		CAKeyKey:                b.CAKey,
+		corev1.TLSCertKey:       b.Cert,
+		corev1.TLSPrivateKeyKey: b.Key,
+	}
+	if len(b.PreviousCACert) > 0 {
+		data[PreviousCACertKey] = b.PreviousCACert
+	}
+
+	if secret == nil {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: opts.SecretName, Namespace: opts.Namespace},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}
+		_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			logger.Info("certificate secret created by another replica")
+			return bootstrapped(ctx, client, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not create secret %s/%s: %v", opts.Namespace, opts.SecretName, err)
+		}
+		logger.Infof("certificates persisted to secret %s/%s", opts.Namespace, opts.SecretName)
+		return b, nil
+	}
+
+	secret = secret.DeepCopy()
+	secret.Data = data
+	_, err := secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		logger.Info("certificate secret updated by another replica")
+		return bootstrapped(ctx, client, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not update secret %s/%s: %v", opts.Namespace, opts.SecretName, err)
+	}
+	logger.Infof("certificates persisted to secret %s/%s", opts.Namespace, opts.SecretName)
+
+	return b, nil
+}
+
+// bootstrapped returns the bundle another replica persisted to the Secret
+func bootstrapped(ctx context.Context, client kubernetes.Interface, opts Options) (*Bundle, error) {
+	secret, err := client.CoreV1().Secrets(opts.Namespace).Get(ctx, opts.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get secret %s/%s: %v", opts.Namespace, opts.SecretName, err)
+	}
+
+	return secretBundle(secret), nil
+}
+
+// secretBundle returns the bundle persisted to the Secret
+func secretBundle(secret *corev1.Secret) *Bundle {
+	return &Bundle{
+		CACert:         secret.Data[CACertKey],
+		CAKey:          secret.Data[CAKeyKey],
+		Cert:           secret.Data[corev1.TLSCertKey],
+		Key:            secret.Data[corev1.TLSPrivateKeyKey],
+		PreviousCACert: secret.Data[PreviousCACertKey],
+	}
+}
+
+// patchCABundles sets the caBundle of all webhooks of the configured webhook
+// configurations, configurations already trusting the CA bundle are left
+// untouched. Updates conflicting with another replica's are retried.
+func patchCABundles(ctx context.Context, logger logrus.FieldLogger, client kubernetes.Interface, caBundle []byte, opts Options) error {
+	mutating := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	for _, name := range opts.MutatingWebhooks {
+		var found, patched bool
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			cfg, err := mutating.Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			found = true
+
+			for i := range cfg.Webhooks {
+				if !bytes.Equal(cfg.Webhooks[i].ClientConfig.CABundle, caBundle) {
+					cfg.Webhooks[i].ClientConfig.CABundle = caBundle
+					patched = true
+				}
+			}
+			if !patched {
+				return nil
+			}
+			_, err = mutating.Update(ctx, cfg, metav1.UpdateOptions{})
+			return err
+		})
+		switch {
+		case err != nil:
+			return fmt.Errorf("could not patch mutating webhook configuration %s: %v", name, err)
+		case !found:
+			logger.Warnf("mutating webhook configuration %s not found, caBundle not patched", name)
+		case patched:
+			logger.Infof("caBundle patched in mutating webhook configuration %s", name)
+		}
+	}
+
+	validating := client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	for _, name := range opts.ValidatingWebhooks {
+		var found, patched bool
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			cfg, err := validating.Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			found = true
+
+			for i := range cfg.Webhooks {
+				if !bytes.Equal(cfg.Webhooks[i].ClientConfig.CABundle, caBundle) {
+					cfg.Webhooks[i].ClientConfig.CABundle = caBundle
+					patched = true
+				}
+			}
+			if !patched {
+				return nil
+			}
+			_, err = validating.Update(ctx, cfg, metav1.UpdateOptions{})
+			return err
+		})
+		switch {
+		case err != nil:
+			return fmt.Errorf("could not patch validating webhook configuration %s: %v", name, err)
+		case !found:
+			logger.Warnf("validating webhook configuration %s not found, caBundle not patched", name)
+		case patched:
+			logger.Infof("caBundle patched in validating webhook configuration %s", name)
+		}
+	}
+
+	return nil
+}
+
+// WriteFiles writes the serving certificate and key to the given files, so
+// that they can be loaded and watched as mounted certificates are. The key is
+// written first and each file is replaced by a rename, so watchers never read
+// a partially written file.
+func (b *Bundle) WriteFiles(certFile, keyFile string) error {
+	if err := writeFile(keyFile, b.Key); err != nil {
+		return err
+	}
+	return writeFile(certFile, b.Cert)
+}
+
+// writeFile atomically replaces file with data, readable by the owner only
+func writeFile(file string, data []byte) error {
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// newCA returns a self-signed CA certificate and its key
+func newCA(name string, now time.Time, validity time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name + "-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ca, key, nil
+}
+
+// newServingCert returns a DER encoded serving certificate signed by the CA
+// for the given DNS names, along with its key. It doesn't outlive the CA.
+func newServingCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, dnsNames []string, now time.Time, validity time.Duration) ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return der, key, nil
+}
+
+// serialNumber returns a random certificate serial number
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// parsePair parses a PEM encoded CA certificate and key
+func parsePair(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := parseCert(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse key: %v", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return cert, ecKey, nil
+}
+
+// parseCert parses a PEM encoded certificate, checking that it matches the
+// PEM encoded key
+func parseCert(certPEM, keyPEM []byte) (*x509.Certificate, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %v", err)
+	}
+
+	return x509.ParseCertificate(pair.Certificate[0])
+}
+
+// validCert returns the PEM encoded certificate if it is still valid at now,
+// nil if it expired or can't be parsed
+func validCert(certPEM []byte, now time.Time) []byte {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || !now.Before(cert.NotAfter) {
+		return nil
+	}
+	return certPEM
+}
+
+// covers returns true if the certificate is valid for all DNS names
+func covers(cert *x509.Certificate, dnsNames []string) bool {
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeCert returns the PEM encoding of a DER certificate
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// encodeKey returns the PEM encoding of a private key
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,343 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func logger() logrus.FieldLogger {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	return l
+}
+
+func options() Options {
+	return Options{
+		Namespace:          "default",
+		SecretName:         "webhook-certs",
+		DNSNames:           []string{"webhook", "webhook.default", "webhook.default.svc"},
+		MutatingWebhooks:   []string{"webhook.acme.com"},
+		ValidatingWebhooks: []string{"webhook.acme.com"},
+	}
+}
+
+func newClient() *fake.Clientset {
+	return fake.NewSimpleClientset(
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook.acme.com"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{Name: "mutate.webhook.acme.com"},
+				{Name: "mutate-pods.webhook.acme.com"},
+			},
+		},
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook.acme.com"},
+			Webhooks: []admissionregistrationv1.ValidatingWebhook{
+				{Name: "validate.webhook.acme.com"},
+			},
+		},
+	)
+}
+
+func secret(t *testing.T, client kubernetes.Interface) *corev1.Secret {
+	s, err := client.CoreV1().Secrets("default").Get(context.Background(), "webhook-certs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// verify checks that the serving certificate is signed by the CA and valid
+// for the DNS names at the given time
+func verify(t *testing.T, b *Bundle, dnsNames []string, at time.Time) {
+	pair, err := tls.X509KeyPair(b.Cert, b.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(b.CACert))
+	for _, name := range dnsNames {
+		_, err := cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots, CurrentTime: at})
+		assert.Nil(t, err, name)
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	client := newClient()
+	ctx := context.Background()
+
+	b, err := Bootstrap(ctx, logger(), client, options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(t, b, options().DNSNames, time.Now())
+
+	s := secret(t, client)
+	assert.Equal(t, corev1.SecretTypeTLS, s.Type)
+	assert.Equal(t, b.CACert, s.Data[CACertKey])
+	assert.Equal(t, b.CAKey, s.Data[CAKeyKey])
+	assert.Equal(t, b.Cert, s.Data[corev1.TLSCertKey])
+	assert.Equal(t, b.Key, s.Data[corev1.TLSPrivateKeyKey])
+
+	mwc, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "webhook.acme.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range mwc.Webhooks {
+		assert.Equal(t, b.CACert, w.ClientConfig.CABundle, w.Name)
+	}
+
+	vwc, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, "webhook.acme.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range vwc.Webhooks {
+		assert.Equal(t, b.CACert, w.ClientConfig.CABundle, w.Name)
+	}
+}
+
+func TestBootstrapReuse(t *testing.T) {
+	client := newClient()
+	ctx := context.Background()
+
+	first, err := Bootstrap(ctx, logger(), client, options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ClearActions()
+
+	second, err := Bootstrap(ctx, logger(), client, options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, first, second)
+
+	for _, a := range client.Actions() {
+		assert.Equal(t, "get", a.GetVerb(), a.GetResource().Resource)
+	}
+}
+
+func TestBootstrapRenewServingCert(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		opts func(*Options)
+	}{
+		{
+			name: "expiring",
+			opts: func(o *Options) {
+				o.Now = func() time.Time { return time.Now().Add(DefaultValidity - 24*time.Hour) }
+			},
+		},
+		{
+			name: "new DNS name",
+			opts: func(o *Options) {
+				o.DNSNames = append(o.DNSNames, "webhook.default.svc.cluster.local")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newClient()
+			first, err := Bootstrap(ctx, logger(), client, options())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := options()
+			tt.opts(&opts)
+			second, err := Bootstrap(ctx, logger(), client, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, first.CACert, second.CACert)
+			assert.Equal(t, first.CAKey, second.CAKey)
+			assert.NotEqual(t, first.Cert, second.Cert)
+			assert.Equal(t, second.Cert, secret(t, client).Data[corev1.TLSCertKey])
+			now := time.Now()
+			if opts.Now != nil {
+				now = opts.Now()
+			}
+			verify(t, second, opts.DNSNames, now)
+		})
+	}
+}
+
+func TestBootstrapRenewCA(t *testing.T) {
+	client := newClient()
+	ctx := context.Background()
+
+	first, err := Bootstrap(ctx, logger(), client, options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, first.PreviousCACert)
+	assert.Equal(t, first.CACert, first.CABundle())
+
+	opts := options()
+	opts.Now = func() time.Time { return time.Now().Add(DefaultCAValidity - 24*time.Hour) }
+	second, err := Bootstrap(ctx, logger(), client, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotEqual(t, first.CACert, second.CACert)
+	assert.Equal(t, first.CACert, second.PreviousCACert)
+	assert.Equal(t, first.CACert, secret(t, client).Data[PreviousCACertKey])
+	verify(t, second, opts.DNSNames, opts.Now())
+
+	// both CAs are trusted until the previous one expires, so that replicas
+	// still serving the first certificate remain trusted
+	mwc, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "webhook.acme.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBundle := mwc.Webhooks[0].ClientConfig.CABundle
+	assert.Equal(t, append(append([]byte{}, second.CACert...), first.CACert...), caBundle)
+	verify(t, &Bundle{CACert: caBundle, Cert: first.Cert, Key: first.Key}, opts.DNSNames, time.Now())
+
+	// the previous CA is dropped once expired
+	opts.Now = func() time.Time { return time.Now().Add(DefaultCAValidity + 24*time.Hour) }
+	third, err := Bootstrap(ctx, logger(), client, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, second.CACert, third.CACert)
+	assert.Equal(t, second.Cert, third.Cert)
+	assert.Nil(t, third.PreviousCACert)
+	assert.NotContains(t, secret(t, client).Data, PreviousCACertKey)
+
+	mwc, err = client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "webhook.acme.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, third.CACert, mwc.Webhooks[0].ClientConfig.CABundle)
+}
+
+func TestBootstrapInvalidSecret(t *testing.T) {
+	client := newClient()
+	ctx := context.Background()
+
+	_, err := client.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-certs", Namespace: "default"},
+		Data:       map[string][]byte{CACertKey: []byte("garbage")},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Bootstrap(ctx, logger(), client, options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(t, b, options().DNSNames, time.Now())
+	assert.Equal(t, b.CACert, secret(t, client).Data[CACertKey])
+}
+
+func TestBootstrapMissingWebhookConfig(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	b, err := Bootstrap(context.Background(), logger(), client, options())
+	assert.Nil(t, err)
+	assert.NotNil(t, b)
+}
+
+func TestBootstrapUpdateConflict(t *testing.T) {
+	client := newClient()
+	conflicts := map[string]int{}
+	client.PrependReactor("update", "*", func(a clienttesting.Action) (bool, runtime.Object, error) {
+		resource := a.GetResource().Resource
+		if resource == "secrets" || conflicts[resource] > 0 {
+			return false, nil, nil
+		}
+		conflicts[resource]++
+		return true, nil, apierrors.NewConflict(a.GetResource().GroupResource(), "webhook.acme.com", errors.New("modified by another replica"))
+	})
+	ctx := context.Background()
+
+	b, err := Bootstrap(ctx, logger(), client, options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]int{"mutatingwebhookconfigurations": 1, "validatingwebhookconfigurations": 1}, conflicts)
+
+	mwc, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "webhook.acme.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range mwc.Webhooks {
+		assert.Equal(t, b.CABundle(), w.ClientConfig.CABundle, w.Name)
+	}
+
+	vwc, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, "webhook.acme.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range vwc.Webhooks {
+		assert.Equal(t, b.CABundle(), w.ClientConfig.CABundle, w.Name)
+	}
+}
+
+func TestBootstrapNoDNSName(t *testing.T) {
+	opts := options()
+	opts.DNSNames = nil
+
+	_, err := Bootstrap(context.Background(), logger(), newClient(), opts)
+	assert.Error(t, err)
+}
+
+func TestWriteFiles(t *testing.T) {
+	b, err := Bootstrap(context.Background(), logger(), newClient(), options())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls", "tls.crt")
+	keyFile := filepath.Join(dir, "tls", "tls.key")
+	if err := b.WriteFiles(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.Nil(t, err)
+
+	// rewriting replaces the files and leaves no temporary files behind
+	if err := b.WriteFiles(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "tls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		assert.Equal(t, os.FileMode(0600), f.Mode().Perm(), f.Name())
+	}
+	assert.Len(t, files, 2)
+}
@@ -0,0 +1,200 @@
+// Package config handles the webhook policy configuration,
+// it declares which mutations and validations are enabled, in which order
+// and with which parameters
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the webhook policy, it lists the mutations and validations applied
+// to pods in order
+type Config struct {
+	Mutators   []Rule `json:"mutators"`
+	Validators []Rule `json:"validators"`
+
+	// Evaluation sets whether validation stops at the first failing validator
+	// or runs all of them, it defaults to EvaluateFirst
+	Evaluation Evaluation `json:"evaluation,omitempty"`
+
+	// Exemptions sets who may exempt objects from rules, nobody can if unset
+	Exemptions *Exemptions `json:"exemptions,omitempty"`
+}
+
+// DefaultExemptionAnnotation is the annotation listing the rules an object is
+// exempted from, unless set otherwise in Exemptions
+const DefaultExemptionAnnotation = "acme.com/skip-rules"
+
+// Exemptions lets authorized users opt objects out of named rules with an
+// annotation, ie. as a break-glass during incidents
+type Exemptions struct {
+	// Annotation is the annotation listing, comma separated, the rules an
+	// object is exempted from, it defaults to DefaultExemptionAnnotation
+	Annotation string `json:"annotation,omitempty"`
+	// Groups lists the user groups allowed to exempt objects
+	Groups []string `json:"groups,omitempty"`
+	// ServiceAccounts lists the service accounts allowed to exempt objects, as
+	// "namespace:name"
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+}
+
+// Evaluation is a validation evaluation mode
+type Evaluation string
+
+const (
+	// EvaluateFirst stops validation at the first failing validator
+	EvaluateFirst Evaluation = "first"
+	// EvaluateAll runs all validators and reports all failures at once
+	EvaluateAll Evaluation = "all"
+)
+
+// Rule enables a single mutation or validation by name, along with its
+// parameters if any
+type Rule struct {
+	Name string `json:"name"`
+	// Mode sets how the rule outcome is enforced, it defaults to ModeEnforce
+	Mode Mode `json:"mode,omitempty"`
+	// Scope restricts the objects the rule applies to, it applies to all
+	// objects if unset
+	Scope  *Scope          `json:"scope,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Scope restricts a rule to a subset of objects, all set conditions must
+// match for the rule to apply
+type Scope struct {
+	// Namespaces lists glob patterns, ie. "team-*", the namespace must match
+	// one of them
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ExcludedNamespaces lists glob patterns the namespace must not match
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// NamespaceSelector matches the labels of the namespace
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ObjectSelector matches the labels of the object
+	ObjectSelector *metav1.LabelSelector `json:"objectSelector,omitempty"`
+	// Names lists glob patterns, the object name (or generate name if it has
+	// none) must match one of them
+	Names []string `json:"names,omitempty"`
+}
+
+// Mode is the enforcement mode of a rule
+type Mode string
+
+const (
+	// ModeEnforce denies objects failing a validator and applies mutations
+	ModeEnforce Mode = "enforce"
+	// ModeWarn admits objects failing a validator and skips mutations, a
+	// warning is returned to the client instead
+	ModeWarn Mode = "warn"
+	// ModeDryRun admits objects failing a validator and skips mutations, the
+	// outcome is only logged and counted in metrics
+	ModeDryRun Mode = "dry-run"
+)
+
+// Default returns the policy used when no config file is given
+func Default() *Config {
+	return &Config{
+		Mutators: []Rule{
+			{Name: "min_lifespan"},
+			{Name: "inject_env"},
+		},
+		Validators: []Rule{
+			{Name: "name_validator"},
+			{Name: "lifespan_label"},
+		},
+	}
+}
+
+// Load reads a YAML or JSON config file from path
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %v", err)
+	}
+
+	return Parse(b)
+}
+
+// Parse parses a YAML or JSON config, unknown fields are rejected
+func Parse(b []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("could not parse config: %v", err)
+	}
+
+	switch c.Evaluation {
+	case "", EvaluateFirst, EvaluateAll:
+	default:
+		return nil, fmt.Errorf("unknown evaluation %q, must be %q or %q",
+			c.Evaluation, EvaluateFirst, EvaluateAll)
+	}
+
+	if c.Exemptions != nil {
+		for _, sa := range c.Exemptions.ServiceAccounts {
+			if parts := strings.Split(sa, ":"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid exemption service account %q, must be \"namespace:name\"", sa)
+			}
+		}
+	}
+
+	for _, r := range c.Mutators {
+		if r.Name == "" {
+			return nil, fmt.Errorf("mutator without a name in config")
+		}
+		if err := r.checkMode(); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range c.Validators {
+		if r.Name == "" {
+			return nil, fmt.Errorf("validator without a name in config")
+		}
+		if err := r.checkMode(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+// Enforcement returns the rule mode, ModeEnforce if unset
+func (r Rule) Enforcement() Mode {
+	if r.Mode == "" {
+		return ModeEnforce
+	}
+	return r.Mode
+}
+
+// checkMode returns an error if the rule mode is unknown
+func (r Rule) checkMode() error {
+	switch r.Mode {
+	case "", ModeEnforce, ModeWarn, ModeDryRun:
+		return nil
+	}
+
+	return fmt.Errorf("unknown mode %q for %q, must be one of %q, %q or %q",
+		r.Mode, r.Name, ModeEnforce, ModeWarn, ModeDryRun)
+}
+
+// DecodeParams decodes the rule parameters into v, unknown fields are
+// rejected. v is left untouched if the rule has no parameters.
+func (r Rule) DecodeParams(v interface{}) error {
+	if len(r.Params) == 0 || string(r.Params) == "null" {
+		return nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader(r.Params))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("invalid params for %q: %v", r.Name, err)
+	}
+
+	return nil
+}
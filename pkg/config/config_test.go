@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		cfg, err := Parse([]byte(`
+mutators:
+  - name: inject_env
+    params:
+      env:
+        - name: CLUSTER
+          value: kind
+validators:
+  - name: name_validator
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, cfg.Mutators, 1)
+		assert.Equal(t, "inject_env", cfg.Mutators[0].Name)
+		assert.JSONEq(t, `{"env":[{"name":"CLUSTER","value":"kind"}]}`, string(cfg.Mutators[0].Params))
+		assert.Equal(t, []Rule{{Name: "name_validator"}}, cfg.Validators)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		cfg, err := Parse([]byte(`{"mutators":[{"name":"min_lifespan"}],"validators":[]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []Rule{{Name: "min_lifespan"}}, cfg.Mutators)
+		assert.Empty(t, cfg.Validators)
+	})
+
+	t.Run("scope", func(t *testing.T) {
+		cfg, err := Parse([]byte(`
+validators:
+  - name: name_validator
+    scope:
+      namespaces: ["team-*"]
+      objectSelector:
+        matchLabels:
+          app: web
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &Scope{
+			Namespaces:     []string{"team-*"},
+			ObjectSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		}
+		assert.Equal(t, want, cfg.Validators[0].Scope)
+	})
+
+	t.Run("exemptions", func(t *testing.T) {
+		cfg, err := Parse([]byte(`
+exemptions:
+  groups: [sre]
+  serviceAccounts: ["ops:breakglass"]
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, &Exemptions{Groups: []string{"sre"}, ServiceAccounts: []string{"ops:breakglass"}}, cfg.Exemptions)
+
+		_, err = Parse([]byte(`exemptions: {serviceAccounts: [breakglass]}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := Parse([]byte(`mutatorz: []`))
+		assert.Error(t, err)
+	})
+
+	t.Run("missing name", func(t *testing.T) {
+		_, err := Parse([]byte(`validators: [{params: {}}]`))
+		assert.Error(t, err)
+	})
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("validators: [{name: name_validator}]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Rule{{Name: "name_validator"}}, cfg.Validators)
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestRuleDecodeParams(t *testing.T) {
+	type params struct {
+		Foo string `json:"foo"`
+	}
+
+	t.Run("no params", func(t *testing.T) {
+		p := params{Foo: "default"}
+		assert.Nil(t, Rule{Name: "test"}.DecodeParams(&p))
+		assert.Equal(t, "default", p.Foo)
+	})
+
+	t.Run("params", func(t *testing.T) {
+		p := params{Foo: "default"}
+		r := Rule{Name: "test", Params: json.RawMessage(`{"foo":"bar"}`)}
+		assert.Nil(t, r.DecodeParams(&p))
+		assert.Equal(t, "bar", p.Foo)
+	})
+
+	t.Run("unknown params", func(t *testing.T) {
+		r := Rule{Name: "test", Params: json.RawMessage(`{"bar":"foo"}`)}
+		assert.Error(t, r.DecodeParams(&params{}))
+	})
+}
+
+func TestParseEvaluation(t *testing.T) {
+	cfg, err := Parse([]byte(`evaluation: all`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, EvaluateAll, cfg.Evaluation)
+
+	_, err = Parse([]byte(`evaluation: some`))
+	assert.Error(t, err)
+}
+
+func TestParseMode(t *testing.T) {
+	cfg, err := Parse([]byte(`
+mutators: [{name: inject_env, mode: dry-run}]
+validators: [{name: name_validator, mode: warn}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ModeDryRun, cfg.Mutators[0].Mode)
+	assert.Equal(t, ModeWarn, cfg.Validators[0].Mode)
+
+	_, err = Parse([]byte(`validators: [{name: name_validator, mode: shadow}]`))
+	assert.Error(t, err)
+}
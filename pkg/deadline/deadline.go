@@ -0,0 +1,33 @@
+// Package deadline bounds the time admission rules take, so that a slow rule
+// fails the admission request deterministically before the API server gives
+// up on the webhook
+package deadline
+
+import (
+	"context"
+	"fmt"
+)
+
+// Run runs f and returns nil once it returns, or an error wrapping ctx.Err()
+// if ctx is done first. In that case f keeps running in the background and
+// anything it writes must be ignored by the caller. Run can't stop f, so f
+// must honour ctx and return once it is done, otherwise every timed out
+// request leaks the goroutine running it.
+func Run(ctx context.Context, name string, f func()) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s not run: %w", name, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s did not complete: %w", name, ctx.Err())
+	}
+}
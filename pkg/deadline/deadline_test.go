@@ -0,0 +1,38 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	ran := false
+	err := Run(context.Background(), "rule", func() { ran = true })
+	assert.Nil(t, err)
+	assert.True(t, ran)
+}
+
+func TestRunTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+	err := Run(ctx, `mutation "slow"`, func() { <-release })
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.EqualError(t, err, `mutation "slow" did not complete: context deadline exceeded`)
+}
+
+func TestRunDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ran := false
+	err := Run(ctx, "rule", func() { ran = true })
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, ran)
+}
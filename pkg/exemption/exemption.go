@@ -0,0 +1,142 @@
+// Package exemption decides which rules an admitted object is exempted from,
+// exemptions are requested with an annotation and only granted to authorized
+// users
+package exemption
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// allRules exempts an object from every rule
+const allRules = "*"
+
+// serviceAccountPrefix prefixes the username of service accounts
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// Policy is a parsed config.Exemptions
+type Policy struct {
+	annotation string
+	groups     map[string]bool
+	usernames  map[string]bool
+}
+
+// New returns the exemption policy for the given config, a nil config grants
+// no exemption
+func New(cfg *config.Exemptions) *Policy {
+	p := &Policy{
+		annotation: config.DefaultExemptionAnnotation,
+		groups:     map[string]bool{},
+		usernames:  map[string]bool{},
+	}
+	if cfg == nil {
+		return p
+	}
+
+	if cfg.Annotation != "" {
+		p.annotation = cfg.Annotation
+	}
+	for _, g := range cfg.Groups {
+		p.groups[g] = true
+	}
+	for _, sa := range cfg.ServiceAccounts {
+		p.usernames[serviceAccountPrefix+sa] = true
+	}
+
+	return p
+}
+
+// Exemptions are the rules an object is exempted from
+type Exemptions map[string]bool
+
+// Exempts returns true if the object is exempted from the named rule
+func (e Exemptions) Exempts(rule string) bool {
+	return e[rule] || e[allRules]
+}
+
+// Exempted returns the rules the given object, admitted under req, is
+// exempted from. Exemptions are granted if the requesting user is allowed, or
+// if the object being replaced already had the same exemptions: once granted
+// an exemption survives updates by other users that leave it untouched. A
+// warning is returned if the object asks for exemptions that are not granted.
+// A nil Policy grants no exemption.
+func (p *Policy) Exempted(req *request.Request, obj metav1.Object) (Exemptions, []string) {
+	if p == nil {
+		return nil, nil
+	}
+
+	value, ok := obj.GetAnnotations()[p.annotation]
+	if !ok {
+		return nil, nil
+	}
+
+	if !p.granted(req, value) {
+		w := fmt.Sprintf("%s annotation ignored, user %q is not allowed to exempt objects from rules",
+			p.annotation, req.UserInfo.Username)
+		return nil, []string{w}
+	}
+
+	exemptions := Exemptions{}
+	for _, r := range strings.Split(value, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			exemptions[r] = true
+		}
+	}
+
+	return exemptions, nil
+}
+
+// CheckTemplate returns an error if the given pod template, admitted under
+// req, asks for exemptions that are not granted. Pods created from a template
+// by controllers are admitted under the controller service account, which
+// must be allowed for them to be exempted: the workload must then be denied
+// so that anyone able to create workloads can't exempt their pods. Templates
+// are not checked if nobody is allowed to exempt objects.
+func (p *Policy) CheckTemplate(req *request.Request, obj metav1.Object) error {
+	if p == nil || !req.Template || len(p.groups)+len(p.usernames) == 0 {
+		return nil
+	}
+
+	value, ok := obj.GetAnnotations()[p.annotation]
+	if !ok || p.granted(req, value) {
+		return nil
+	}
+
+	return fmt.Errorf("user %q is not allowed to exempt pods from rules with the %s annotation",
+		req.UserInfo.Username, p.annotation)
+}
+
+// granted returns true if the exemptions requested with the given annotation
+// value are granted
+func (p *Policy) granted(req *request.Request, value string) bool {
+	return p.authorized(req) || p.unchanged(req, value)
+}
+
+// authorized returns true if the requesting user may grant exemptions
+func (p *Policy) authorized(req *request.Request) bool {
+	if p.usernames[req.UserInfo.Username] {
+		return true
+	}
+	for _, g := range req.UserInfo.Groups {
+		if p.groups[g] {
+			return true
+		}
+	}
+	return false
+}
+
+// unchanged returns true if the object being replaced carries the same
+// exemption annotation value
+func (p *Policy) unchanged(req *request.Request, value string) bool {
+	old, ok := req.OldObject.(metav1.Object)
+	if !ok {
+		return false
+	}
+
+	oldValue, ok := old.GetAnnotations()[p.annotation]
+	return ok && oldValue == value
+}
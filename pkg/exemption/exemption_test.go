@@ -0,0 +1,118 @@
+package exemption
+
+import (
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPolicyExempted(t *testing.T) {
+	p := New(&config.Exemptions{
+		Groups:          []string{"sre"},
+		ServiceAccounts: []string{"ops:breakglass"},
+	})
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{config.DefaultExemptionAnnotation: "name_validator, min_lifespan"},
+	}}
+	want := Exemptions{"name_validator": true, "min_lifespan": true}
+
+	for _, tc := range []struct {
+		name     string
+		user     authenticationv1.UserInfo
+		want     Exemptions
+		warnings int
+	}{
+		{"group", authenticationv1.UserInfo{Username: "alice", Groups: []string{"dev", "sre"}}, want, 0},
+		{"service account", authenticationv1.UserInfo{Username: "system:serviceaccount:ops:breakglass"}, want, 0},
+		{"unauthorized", authenticationv1.UserInfo{Username: "bob", Groups: []string{"dev"}}, nil, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &request.Request{Operation: admissionv1.Create, UserInfo: tc.user}
+
+			got, warnings := p.Exempted(req, pod)
+			assert.Equal(t, tc.want, got)
+			assert.Len(t, warnings, tc.warnings)
+		})
+	}
+
+	t.Run("no annotation", func(t *testing.T) {
+		got, warnings := p.Exempted(&request.Request{}, &corev1.Pod{})
+		assert.Nil(t, got)
+		assert.Nil(t, warnings)
+	})
+
+	t.Run("unchanged on update", func(t *testing.T) {
+		req := &request.Request{
+			Operation: admissionv1.Update,
+			UserInfo:  authenticationv1.UserInfo{Username: "bob"},
+			OldObject: pod.DeepCopy(),
+		}
+
+		got, warnings := p.Exempted(req, pod)
+		assert.Equal(t, want, got)
+		assert.Nil(t, warnings)
+
+		// widening the exemption needs an authorized user
+		changed := pod.DeepCopy()
+		changed.Annotations[config.DefaultExemptionAnnotation] = "*"
+		got, warnings = p.Exempted(req, changed)
+		assert.Nil(t, got)
+		assert.Len(t, warnings, 1)
+	})
+}
+
+func TestPolicyExemptedNil(t *testing.T) {
+	var p *Policy
+	got, warnings := p.Exempted(&request.Request{}, &corev1.Pod{})
+	assert.Nil(t, got)
+	assert.Nil(t, warnings)
+
+	// without config the default annotation is ignored with a warning
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{config.DefaultExemptionAnnotation: "*"},
+	}}
+	got, warnings = New(nil).Exempted(&request.Request{}, pod)
+	assert.Nil(t, got)
+	assert.Len(t, warnings, 1)
+}
+
+func TestPolicyCheckTemplate(t *testing.T) {
+	p := New(&config.Exemptions{Groups: []string{"sre"}})
+	tmpl := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{config.DefaultExemptionAnnotation: "*"},
+	}}
+	bob := authenticationv1.UserInfo{Username: "bob", Groups: []string{"dev"}}
+
+	req := &request.Request{Operation: admissionv1.Create, Template: true, UserInfo: bob}
+	assert.EqualError(t, p.CheckTemplate(req, tmpl),
+		`user "bob" is not allowed to exempt pods from rules with the acme.com/skip-rules annotation`)
+	assert.Nil(t, p.CheckTemplate(req, &corev1.Pod{}))
+
+	// pods are not templates, the annotation is only ignored
+	assert.Nil(t, p.CheckTemplate(&request.Request{Operation: admissionv1.Create, UserInfo: bob}, tmpl))
+
+	req.UserInfo = authenticationv1.UserInfo{Username: "alice", Groups: []string{"sre"}}
+	assert.Nil(t, p.CheckTemplate(req, tmpl))
+
+	req = &request.Request{Operation: admissionv1.Update, Template: true, UserInfo: bob, OldObject: tmpl.DeepCopy()}
+	assert.Nil(t, p.CheckTemplate(req, tmpl))
+
+	// without anybody allowed, pods can't be exempted either
+	req = &request.Request{Operation: admissionv1.Create, Template: true, UserInfo: bob}
+	assert.Nil(t, New(nil).CheckTemplate(req, tmpl))
+	assert.Nil(t, (*Policy)(nil).CheckTemplate(req, tmpl))
+}
+
+func TestExemptionsExempts(t *testing.T) {
+	assert.True(t, Exemptions{"name_validator": true}.Exempts("name_validator"))
+	assert.False(t, Exemptions{"name_validator": true}.Exempts("min_lifespan"))
+	assert.True(t, Exemptions{"*": true}.Exempts("min_lifespan"))
+	assert.False(t, Exemptions(nil).Exempts("min_lifespan"))
+}
@@ -0,0 +1,106 @@
+// Package image parses container image references the way container runtimes
+// resolve them, so that rules can match their registry, tag and digest
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultRegistry is the registry of images whose name has no registry
+const DefaultRegistry = "docker.io"
+
+// LatestTag is the tag pulled for images without a tag nor a digest
+const LatestTag = "latest"
+
+var (
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp        = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp     = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+)
+
+// Reference is a parsed image reference, ie. docker.io/library/nginx:1.21
+type Reference struct {
+	Registry   string
+	Repository string
+	// Tag is empty if the image has no tag
+	Tag string
+	// Digest is empty if the image is not pinned by digest
+	Digest string
+}
+
+// Parse parses an image reference as found in a container spec. Images
+// without a registry are normalized to DefaultRegistry, and to its library
+// repository for single component names, ie. nginx is
+// docker.io/library/nginx.
+func Parse(s string) (Reference, error) {
+	var ref Reference
+	if s == "" {
+		return ref, fmt.Errorf("empty image reference")
+	}
+
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !digestRegexp.MatchString(ref.Digest) {
+			return ref, fmt.Errorf("invalid digest %q in image %q", ref.Digest, s)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return ref, fmt.Errorf("invalid tag %q in image %q", ref.Tag, s)
+		}
+	}
+
+	ref.Registry, ref.Repository = DefaultRegistry, name
+	if i := strings.Index(name, "/"); i >= 0 {
+		if host := name[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry, ref.Repository = host, name[i+1:]
+		}
+	}
+	if ref.Registry == "index.docker.io" {
+		ref.Registry = DefaultRegistry
+	}
+	if ref.Registry == DefaultRegistry && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+
+	if !repositoryRegexp.MatchString(ref.Repository) {
+		return ref, fmt.Errorf("invalid repository %q in image %q", ref.Repository, s)
+	}
+
+	return ref, nil
+}
+
+// Name returns the registry and repository of the image
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the normalized image reference
+func (r Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Latest returns true if the image resolves to the latest tag, that is if it
+// is tagged latest or has no tag, and is not pinned by digest
+func (r Reference) Latest() bool {
+	return r.Digest == "" && (r.Tag == "" || r.Tag == LatestTag)
+}
+
+// HasPrefix returns true if the image is in the given registry or
+// repository, ie. docker.io or gcr.io/acme. Only whole path components match,
+// gcr.io/acme doesn't match gcr.io/acme-corp/app.
+func (r Reference) HasPrefix(prefix string) bool {
+	name := r.Name()
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
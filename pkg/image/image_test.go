@@ -0,0 +1,68 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		image string
+		want  Reference
+	}{
+		{"nginx", Reference{Registry: "docker.io", Repository: "library/nginx"}},
+		{"nginx:1.21", Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "1.21"}},
+		{"acme/app:v1", Reference{Registry: "docker.io", Repository: "acme/app", Tag: "v1"}},
+		{"index.docker.io/nginx", Reference{Registry: "docker.io", Repository: "library/nginx"}},
+		{"gcr.io/acme/app:v1.2.3", Reference{Registry: "gcr.io", Repository: "acme/app", Tag: "v1.2.3"}},
+		{"localhost/app", Reference{Registry: "localhost", Repository: "app"}},
+		{"registry:5000/team/app:dev", Reference{Registry: "registry:5000", Repository: "team/app", Tag: "dev"}},
+		{"quay.io/app@" + digest, Reference{Registry: "quay.io", Repository: "app", Digest: digest}},
+		{"quay.io/app:v1@" + digest, Reference{Registry: "quay.io", Repository: "app", Tag: "v1", Digest: digest}},
+	} {
+		t.Run(tc.image, func(t *testing.T) {
+			got, err := Parse(tc.image)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, image := range []string{
+		"",
+		"Nginx",
+		"nginx:",
+		"nginx:-tag",
+		"nginx@sha256",
+		"gcr.io/",
+	} {
+		t.Run(image, func(t *testing.T) {
+			_, err := Parse(image)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestReference(t *testing.T) {
+	ref, err := Parse("nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "docker.io/library/nginx", ref.String())
+	assert.True(t, ref.Latest())
+	assert.True(t, ref.HasPrefix("docker.io"))
+	assert.True(t, ref.HasPrefix("docker.io/library"))
+	assert.False(t, ref.HasPrefix("docker.io/lib"))
+
+	ref, err = Parse("gcr.io/acme/app:latest@" + digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "gcr.io/acme/app:latest@"+digest, ref.String())
+	assert.False(t, ref.Latest())
+	assert.False(t, ref.HasPrefix("gcr.io/acme-corp"))
+}
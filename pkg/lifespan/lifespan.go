@@ -0,0 +1,245 @@
+// Package lifespan describes lifespan taint families: nodes are tainted with
+// their remaining lifespan in days and pods request a minimum lifespan with a
+// label, pods are then given tolerations for nodes old enough to host them
+package lifespan
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Defaults of the family used when none is configured
+const (
+	DefaultLabelKey = "acme.com/lifespan-requested"
+	DefaultTaintKey = "acme.com/lifespan-remaining"
+	DefaultMaxAge   = 14
+)
+
+// Names of the rules configured with lifespan families, the min_lifespan
+// mutation tolerates the labels the lifespan_label validation checks
+const (
+	MutationName   = "min_lifespan"
+	ValidationName = "lifespan_label"
+)
+
+// Unlabelled sets how pods without the lifespan label of a family are handled
+type Unlabelled string
+
+const (
+	// UnlabelledAny tolerates nodes of any remaining lifespan
+	UnlabelledAny Unlabelled = "any"
+	// UnlabelledNone adds no toleration, unlabelled pods can't be scheduled on
+	// tainted nodes
+	UnlabelledNone Unlabelled = "none"
+	// UnlabelledDefault applies the family default lifespan
+	UnlabelledDefault Unlabelled = "default"
+)
+
+// Family is a set of nodes tainted with their remaining lifespan under the
+// same taint key, ie. spot and on-demand node pools
+type Family struct {
+	// LabelKey is the pod label requesting a minimum lifespan in days
+	LabelKey string `json:"labelKey"`
+	// TaintKey is the node taint whose value is the remaining lifespan in days
+	TaintKey string `json:"taintKey"`
+	// Effects are the effects of the node taint, NoSchedule if unset
+	Effects []corev1.TaintEffect `json:"effects,omitempty"`
+	// MinAge and MaxAge are the range of remaining lifespans nodes are
+	// tainted with, MaxAge is DefaultMaxAge if unset
+	MinAge int `json:"minAge,omitempty"`
+	MaxAge int `json:"maxAge,omitempty"`
+	// Unlabelled sets how pods without the label are handled, UnlabelledAny
+	// if unset
+	Unlabelled Unlabelled `json:"unlabelled,omitempty"`
+	// DefaultLifespan is the lifespan applied to unlabelled pods with
+	// UnlabelledDefault
+	DefaultLifespan int `json:"defaultLifespan,omitempty"`
+}
+
+// Default returns the family used when none is configured
+func Default() Family {
+	return Family{
+		LabelKey:   DefaultLabelKey,
+		TaintKey:   DefaultTaintKey,
+		Effects:    []corev1.TaintEffect{corev1.TaintEffectNoSchedule},
+		MaxAge:     DefaultMaxAge,
+		Unlabelled: UnlabelledAny,
+	}
+}
+
+// Families decodes the families listed under `families` in the rule params,
+// unset fields are defaulted. The default family is returned if there are
+// none.
+func Families(rule config.Rule) ([]Family, error) {
+	params := struct {
+		Families []Family `json:"families"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	if len(params.Families) == 0 {
+		return []Family{Default()}, nil
+	}
+
+	labels := map[string]bool{}
+	for i := range params.Families {
+		f := &params.Families[i]
+		if err := f.check(); err != nil {
+			return nil, fmt.Errorf("invalid lifespan family %d in %q: %v", i, rule.Name, err)
+		}
+		if labels[f.LabelKey] {
+			return nil, fmt.Errorf("lifespan label %q is used by more than one family in %q", f.LabelKey, rule.Name)
+		}
+		labels[f.LabelKey] = true
+	}
+
+	return params.Families, nil
+}
+
+// CheckShared returns an error if the min_lifespan and lifespan_label rules
+// of cfg are not all configured with the same families, pods would otherwise
+// be given tolerations for labels the validation denies or the other way
+// around
+func CheckShared(cfg *config.Config) error {
+	var rules []config.Rule
+	for _, r := range cfg.Mutators {
+		if r.Name == MutationName {
+			rules = append(rules, r)
+		}
+	}
+	for _, r := range cfg.Validators {
+		if r.Name == ValidationName {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) < 2 {
+		return nil
+	}
+
+	shared, err := Families(rules[0])
+	if err != nil {
+		return err
+	}
+	for _, r := range rules[1:] {
+		families, err := Families(r)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(shared, families) {
+			return fmt.Errorf("%q and %q must be configured with the same lifespan families", rules[0].Name, r.Name)
+		}
+	}
+
+	return nil
+}
+
+// Label is the lifespan label of a family on a pod
+type Label struct {
+	Family
+	// Value is the label value, empty if the pod has no such label
+	Value string
+}
+
+// Labels returns the lifespan label of each family on a pod with the given
+// labels, in the order of the families
+func Labels(families []Family, labels map[string]string) []Label {
+	l := make([]Label, len(families))
+	for i, f := range families {
+		l[i] = Label{Family: f, Value: labels[f.LabelKey]}
+	}
+	return l
+}
+
+// check defaults unset fields and returns an error if the family is invalid
+func (f *Family) check() error {
+	if f.LabelKey == "" || f.TaintKey == "" {
+		return fmt.Errorf("labelKey and taintKey are required")
+	}
+
+	if len(f.Effects) == 0 {
+		f.Effects = []corev1.TaintEffect{corev1.TaintEffectNoSchedule}
+	}
+	for _, e := range f.Effects {
+		switch e {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return fmt.Errorf("unknown taint effect %q", e)
+		}
+	}
+
+	if f.MaxAge == 0 {
+		f.MaxAge = DefaultMaxAge
+	}
+	if f.MinAge < 0 || f.MaxAge < f.MinAge {
+		return fmt.Errorf("invalid age range [%d, %d]", f.MinAge, f.MaxAge)
+	}
+
+	switch f.Unlabelled {
+	case "":
+		f.Unlabelled = UnlabelledAny
+	case UnlabelledAny, UnlabelledNone:
+	case UnlabelledDefault:
+		if f.DefaultLifespan < f.MinAge || f.DefaultLifespan > f.MaxAge {
+			return fmt.Errorf("default lifespan %d out of range [%d, %d]", f.DefaultLifespan, f.MinAge, f.MaxAge)
+		}
+	default:
+		return fmt.Errorf("unknown unlabelled policy %q, must be %q, %q or %q",
+			f.Unlabelled, UnlabelledAny, UnlabelledNone, UnlabelledDefault)
+	}
+
+	return nil
+}
+
+// Lifespan parses the lifespan requested by a label value, it returns an
+// error if the value is not an integer, is negative or is greater than the
+// family max age as no node could then host the pod
+func (f Family) Lifespan(value string) (int, error) {
+	l, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("lifespan label %s=%q is not an integer", f.LabelKey, value)
+	}
+	if l < 0 {
+		return 0, fmt.Errorf("lifespan label %s=%q is negative", f.LabelKey, value)
+	}
+	if l > f.MaxAge {
+		return 0, fmt.Errorf("lifespan label %s=%q is greater than the max lifespan of %d days", f.LabelKey, value, f.MaxAge)
+	}
+
+	return l, nil
+}
+
+// Tolerations returns the tolerations for nodes of the family with a remaining
+// lifespan of at least minLifespan days, from the oldest to the newest nodes
+func (f Family) Tolerations(minLifespan int) []corev1.Toleration {
+	t := []corev1.Toleration{}
+	for i := f.MaxAge; i >= minLifespan && i >= f.MinAge; i-- {
+		for _, e := range f.Effects {
+			t = append(t, corev1.Toleration{
+				Key:      f.TaintKey,
+				Operator: corev1.TolerationOpEqual,
+				Effect:   e,
+				Value:    fmt.Sprint(i),
+			})
+		}
+	}
+	return t
+}
+
+// AnyTolerations returns the tolerations for nodes of the family of any
+// remaining lifespan
+func (f Family) AnyTolerations() []corev1.Toleration {
+	t := []corev1.Toleration{}
+	for _, e := range f.Effects {
+		t = append(t, corev1.Toleration{
+			Key:      f.TaintKey,
+			Operator: corev1.TolerationOpExists,
+			Effect:   e,
+		})
+	}
+	return t
+}
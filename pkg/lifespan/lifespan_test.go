@@ -0,0 +1,127 @@
+package lifespan
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestFamilies(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		got, err := Families(config.Rule{Name: "min_lifespan"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []Family{Default()}, got)
+	})
+
+	t.Run("defaulted fields", func(t *testing.T) {
+		rule := config.Rule{
+			Name:   "min_lifespan",
+			Params: json.RawMessage(`{"families":[{"labelKey":"a","taintKey":"b","maxAge":30}]}`),
+		}
+		got, err := Families(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []Family{{
+			LabelKey:   "a",
+			TaintKey:   "b",
+			Effects:    []corev1.TaintEffect{corev1.TaintEffectNoSchedule},
+			MaxAge:     30,
+			Unlabelled: UnlabelledAny,
+		}}
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("defaulted max age", func(t *testing.T) {
+		rule := config.Rule{
+			Name:   "min_lifespan",
+			Params: json.RawMessage(`{"families":[{"labelKey":"a","taintKey":"b"}]}`),
+		}
+		got, err := Families(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, DefaultMaxAge, got[0].MaxAge)
+		assert.Len(t, got[0].Tolerations(0), DefaultMaxAge+1)
+	})
+
+	for name, params := range map[string]string{
+		"no keys":           `{"families":[{"maxAge":30}]}`,
+		"bad effect":        `{"families":[{"labelKey":"a","taintKey":"b","maxAge":30,"effects":["Nope"]}]}`,
+		"bad range":         `{"families":[{"labelKey":"a","taintKey":"b","minAge":10,"maxAge":5}]}`,
+		"negative min":      `{"families":[{"labelKey":"a","taintKey":"b","minAge":-1,"maxAge":5}]}`,
+		"negative max":      `{"families":[{"labelKey":"a","taintKey":"b","maxAge":-1}]}`,
+		"min over default":  `{"families":[{"labelKey":"a","taintKey":"b","minAge":20}]}`,
+		"bad unlabelled":    `{"families":[{"labelKey":"a","taintKey":"b","maxAge":5,"unlabelled":"nope"}]}`,
+		"bad default":       `{"families":[{"labelKey":"a","taintKey":"b","maxAge":5,"unlabelled":"default","defaultLifespan":6}]}`,
+		"duplicated labels": `{"families":[{"labelKey":"a","taintKey":"b","maxAge":5},{"labelKey":"a","taintKey":"c","maxAge":5}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Families(config.Rule{Name: "min_lifespan", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFamilyTolerations(t *testing.T) {
+	f := Family{
+		TaintKey: "spot",
+		Effects:  []corev1.TaintEffect{corev1.TaintEffectNoSchedule, corev1.TaintEffectNoExecute},
+		MinAge:   2,
+		MaxAge:   3,
+	}
+
+	want := []corev1.Toleration{
+		{Key: "spot", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoSchedule, Value: "3"},
+		{Key: "spot", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoExecute, Value: "3"},
+		{Key: "spot", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoSchedule, Value: "2"},
+		{Key: "spot", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoExecute, Value: "2"},
+	}
+	assert.Equal(t, want, f.Tolerations(0))
+	assert.Equal(t, want[:2], f.Tolerations(3))
+	assert.Empty(t, f.Tolerations(4))
+
+	want = []corev1.Toleration{
+		{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+		{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+	}
+	assert.Equal(t, want, f.AnyTolerations())
+}
+
+func TestLabels(t *testing.T) {
+	spot := Family{LabelKey: "acme.com/spot-lifespan", TaintKey: "acme.com/spot-remaining"}
+	families := []Family{Default(), spot}
+
+	got := Labels(families, map[string]string{"acme.com/spot-lifespan": "3", "app": "web"})
+	assert.Equal(t, []Label{{Family: Default()}, {Family: spot, Value: "3"}}, got)
+}
+
+func TestCheckShared(t *testing.T) {
+	spot := json.RawMessage(`{"families":[{"labelKey":"a","taintKey":"b","maxAge":3}]}`)
+	// fields defaulted by Families don't have to be repeated
+	spotDefaulted := json.RawMessage(`{"families":[{"labelKey":"a","taintKey":"b","maxAge":3,"unlabelled":"any"}]}`)
+
+	for _, tc := range []struct {
+		name       string
+		mutators   []config.Rule
+		validators []config.Rule
+		err        bool
+	}{
+		{"defaults", []config.Rule{{Name: MutationName}}, []config.Rule{{Name: ValidationName}}, false},
+		{"same families", []config.Rule{{Name: MutationName, Params: spot}}, []config.Rule{{Name: ValidationName, Params: spotDefaulted}}, false},
+		{"mutation only", []config.Rule{{Name: MutationName, Params: spot}}, nil, false},
+		{"validation defaulted", []config.Rule{{Name: MutationName, Params: spot}}, []config.Rule{{Name: ValidationName}}, true},
+		{"mutations differ", []config.Rule{{Name: MutationName, Params: spot}, {Name: MutationName}}, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckShared(&config.Config{Mutators: tc.mutators, Validators: tc.validators})
+			assert.Equal(t, tc.err, err != nil, "%v", err)
+		})
+	}
+}
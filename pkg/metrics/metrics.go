@@ -0,0 +1,119 @@
+// Package metrics holds the prometheus metrics exposed by the webhook,
+// covering admission decisions, rule latencies, emitted json patches,
+// rejected callers and certificate expiry
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "admission_webhook"
+
+// Admission outcomes used as the outcome label of Requests
+const (
+	OutcomeAllowed = "allowed"
+	OutcomeDenied  = "denied"
+	OutcomeError   = "error"
+	OutcomeTimeout = "timeout"
+)
+
+// Client rejection reasons used as the reason label of ClientRejections
+const (
+	RejectionNoCertificate = "no_certificate"
+	RejectionUntrusted     = "untrusted"
+	RejectionNotAllowed    = "not_allowed"
+)
+
+// Rule kinds used as the kind label of RuleDuration
+const (
+	KindMutator   = "mutator"
+	KindValidator = "validator"
+)
+
+var (
+	// Requests counts admission requests by endpoint, operation, namespace and
+	// outcome
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Admission requests by endpoint, operation, namespace and outcome.",
+	}, []string{"endpoint", "operation", "namespace", "outcome"})
+
+	// RequestDuration observes how long admission requests take to be served,
+	// buckets go up to the 2s webhook timeout
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to serve admission requests.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 1.5, 2},
+	}, []string{"endpoint"})
+
+	// RuleDuration observes how long each mutator and validator takes
+	RuleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rule_duration_seconds",
+		Help:      "Time taken by each mutator and validator.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .5, 1, 2},
+	}, []string{"kind", "rule"})
+
+	// RuleTriggers counts how many times a rule was triggered, ie. a validator
+	// failed or a mutator changed an object, by enforcement mode
+	RuleTriggers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_triggers_total",
+		Help:      "Times a validator failed or a mutator changed an object, by enforcement mode.",
+	}, []string{"kind", "rule", "mode"})
+
+	// RuleErrors counts how many times a rule failed to run, by enforcement
+	// mode. Errors of rules that are not enforced don't fail the request.
+	RuleErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_errors_total",
+		Help:      "Times a mutator or validator failed to run, by enforcement mode.",
+	}, []string{"kind", "rule", "mode"})
+
+	// RuleExemptions counts how many times an object was exempted from a rule
+	// by an exemption annotation
+	RuleExemptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_exemptions_total",
+		Help:      "Times an object was exempted from a rule by annotation.",
+	}, []string{"kind", "rule"})
+
+	// PatchOperations counts json patch operations emitted by mutations by
+	// operation type
+	PatchOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "patch_operations_total",
+		Help:      "JSON patch operations emitted by mutations by operation type.",
+	}, []string{"op"})
+
+	// ClientRejections counts admission requests rejected because the caller
+	// didn't authenticate with an allowed client certificate, by reason
+	ClientRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_rejections_total",
+		Help:      "Admission requests rejected by client certificate authentication, by reason.",
+	}, []string{"reason"})
+)
+
+// RegisterCertificateExpiry exposes the expiry of the serving certificate
+// returned by notAfter, so that expiring certificates can be alerted on
+func RegisterCertificateExpiry(notAfter func() time.Time) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Expiry of the serving certificate, in seconds since the epoch.",
+	}, func() float64 { return float64(notAfter().Unix()) }))
+}
+
+func init() {
+	prometheus.MustRegister(Requests, RequestDuration, RuleDuration, RuleTriggers, RuleErrors, RuleExemptions, PatchOperations, ClientRejections)
+}
+
+// ObserveRule records the time taken by a mutator or validator since start
+func ObserveRule(kind, rule string, start time.Time) {
+	RuleDuration.WithLabelValues(kind, rule).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveRule(t *testing.T) {
+	before := testutil.CollectAndCount(RuleDuration)
+
+	ObserveRule(KindMutator, "test_rule", time.Now().Add(-time.Millisecond))
+	ObserveRule(KindValidator, "test_rule", time.Now())
+
+	assert.Equal(t, before+2, testutil.CollectAndCount(RuleDuration))
+}
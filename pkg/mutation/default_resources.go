@@ -0,0 +1,157 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/scope"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultResources is a container for the mutation defaulting container
+// resource requests and limits
+type defaultResources struct {
+	Logger   logrus.FieldLogger
+	Defaults []resourceDefaults
+}
+
+// defaultResources implements the podMutator interface
+var _ podMutator = (*defaultResources)(nil)
+
+// resourceDefaults are the requests and limits given to the containers of the
+// pods in scope
+type resourceDefaults struct {
+	// Scope restricts the defaults to some namespaces or pods, all pods are
+	// in scope if unset
+	Scope    *config.Scope       `json:"scope,omitempty"`
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+	Limits   corev1.ResourceList `json:"limits,omitempty"`
+
+	scope *scope.Scope
+}
+
+// newDefaultResources returns a defaultResources configured with the defaults
+// listed under `defaults` in the rule params, the first defaults in scope of a
+// pod apply
+func newDefaultResources(logger logrus.FieldLogger, rule config.Rule) (podMutator, error) {
+	params := struct {
+		Defaults []resourceDefaults `json:"defaults"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	for i := range params.Defaults {
+		d := &params.Defaults[i]
+		if err := d.check(); err != nil {
+			return nil, fmt.Errorf("invalid resource defaults %d in %q: %v", i, rule.Name, err)
+		}
+	}
+
+	return defaultResources{Logger: logger, Defaults: params.Defaults}, nil
+}
+
+// check parses the scope and returns an error if the defaults are invalid
+func (d *resourceDefaults) check() error {
+	for _, list := range []corev1.ResourceList{d.Requests, d.Limits} {
+		for name, q := range list {
+			if q.Sign() < 0 {
+				return fmt.Errorf("%s %s is negative", name, q.String())
+			}
+		}
+	}
+	for name, request := range d.Requests {
+		if limit, ok := d.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return fmt.Errorf("%s request %s is greater than the limit %s", name, request.String(), limit.String())
+		}
+	}
+
+	sc, err := scope.New(d.Scope)
+	if err != nil {
+		return fmt.Errorf("invalid scope: %v", err)
+	}
+	d.scope = sc
+
+	return nil
+}
+
+// paramScopes returns the scopes of the defaults
+func (dr defaultResources) paramScopes() []*scope.Scope {
+	scopes := make([]*scope.Scope, len(dr.Defaults))
+	for i, d := range dr.Defaults {
+		scopes[i] = d.scope
+	}
+	return scopes
+}
+
+// Name returns the defaultResources short name
+func (dr defaultResources) Name() string {
+	return "default_resources"
+}
+
+// Mutate returns a new mutated pod whose containers and init containers are
+// given the requests and limits they don't set, only new pods and pod
+// templates are mutated. A default request greater than the container limit
+// is not set, the API server then defaults the request to the limit. A
+// default limit lower than the container request is not set either.
+func (dr defaultResources) Mutate(ctx context.Context, req *request.Request, pod *corev1.Pod) (*corev1.Pod, error) {
+	dr.Logger = req.Log(dr.Logger).WithField("mutation", dr.Name())
+	mpod := pod.DeepCopy()
+
+	if !req.MutablePod() {
+		return mpod, nil
+	}
+
+	for _, d := range dr.Defaults {
+		inScope, err := d.scope.Matches(req, pod)
+		if err != nil {
+			return nil, err
+		}
+		if !inScope {
+			continue
+		}
+
+		for i := range mpod.Spec.InitContainers {
+			defaultContainerResources(&mpod.Spec.InitContainers[i], d)
+		}
+		for i := range mpod.Spec.Containers {
+			defaultContainerResources(&mpod.Spec.Containers[i], d)
+		}
+		break
+	}
+
+	return mpod, nil
+}
+
+// defaultContainerResources sets the default requests and limits the
+// container doesn't set and that are consistent with the ones it sets
+func defaultContainerResources(c *corev1.Container, d resourceDefaults) {
+	for name, limit := range d.Limits {
+		if _, ok := c.Resources.Limits[name]; ok {
+			continue
+		}
+		if request, ok := c.Resources.Requests[name]; ok && request.Cmp(limit) > 0 {
+			continue
+		}
+		if c.Resources.Limits == nil {
+			c.Resources.Limits = corev1.ResourceList{}
+		}
+		c.Resources.Limits[name] = limit.DeepCopy()
+	}
+
+	for name, request := range d.Requests {
+		if _, ok := c.Resources.Requests[name]; ok {
+			continue
+		}
+		if limit, ok := c.Resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			continue
+		}
+		if c.Resources.Requests == nil {
+			c.Resources.Requests = corev1.ResourceList{}
+		}
+		c.Resources.Requests[name] = request.DeepCopy()
+	}
+}
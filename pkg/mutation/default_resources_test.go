@@ -0,0 +1,137 @@
+package mutation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// resourcesRule returns a default_resources rule with larger defaults for
+// the batch team than for everyone else
+func resourcesRule() config.Rule {
+	return config.Rule{
+		Name: "default_resources",
+		Params: json.RawMessage(`{"defaults":[
+			{
+				"scope": {"objectSelector": {"matchLabels": {"team": "batch"}}},
+				"requests": {"cpu": "1", "memory": "1Gi"},
+				"limits": {"memory": "2Gi"}
+			},
+			{
+				"requests": {"cpu": "100m", "memory": "128Mi"},
+				"limits": {"cpu": "500m", "memory": "256Mi"}
+			}
+		]}`),
+	}
+}
+
+// resources returns the given requests and limits, as name, quantity pairs
+func resources(requests, limits []string) corev1.ResourceRequirements {
+	r := corev1.ResourceRequirements{}
+	if requests != nil {
+		r.Requests = corev1.ResourceList{}
+		for i := 0; i < len(requests); i += 2 {
+			r.Requests[corev1.ResourceName(requests[i])] = resource.MustParse(requests[i+1])
+		}
+	}
+	if limits != nil {
+		r.Limits = corev1.ResourceList{}
+		for i := 0; i < len(limits); i += 2 {
+			r.Limits[corev1.ResourceName(limits[i])] = resource.MustParse(limits[i+1])
+		}
+	}
+	return r
+}
+
+func TestDefaultResourcesMutate(t *testing.T) {
+	m, err := newDefaultResources(logger(), resourcesRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		given  corev1.ResourceRequirements
+		want   corev1.ResourceRequirements
+	}{
+		{
+			name: "no resources",
+			want: resources([]string{"cpu", "100m", "memory", "128Mi"}, []string{"cpu", "500m", "memory", "256Mi"}),
+		},
+		{
+			name:   "team defaults",
+			labels: map[string]string{"team": "batch"},
+			want:   resources([]string{"cpu", "1", "memory", "1Gi"}, []string{"memory", "2Gi"}),
+		},
+		{
+			name:  "set values are kept",
+			given: resources([]string{"cpu", "200m"}, []string{"memory", "1Gi"}),
+			want:  resources([]string{"cpu", "200m", "memory", "128Mi"}, []string{"cpu", "500m", "memory", "1Gi"}),
+		},
+		{
+			name:  "default request above the limit",
+			given: resources(nil, []string{"cpu", "50m", "memory", "64Mi"}),
+			want:  resources(nil, []string{"cpu", "50m", "memory", "64Mi"}),
+		},
+		{
+			name:  "default limit below the request",
+			given: resources([]string{"cpu", "1", "memory", "1Gi"}, nil),
+			want:  resources([]string{"cpu", "1", "memory", "1Gi"}, nil),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Labels: tc.labels},
+				Spec: corev1.PodSpec{
+					Containers:     []corev1.Container{{Name: "app", Resources: tc.given}},
+					InitContainers: []corev1.Container{{Name: "init", Resources: tc.given}},
+				},
+			}
+
+			got, err := m.Mutate(context.Background(), createRequest(), pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tc.want, got.Spec.Containers[0].Resources)
+			assert.Equal(t, tc.want, got.Spec.InitContainers[0].Resources)
+		})
+	}
+}
+
+func TestDefaultResourcesUpdate(t *testing.T) {
+	m, err := newDefaultResources(logger(), resourcesRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+
+	got, err := m.Mutate(context.Background(), &request.Request{Operation: admissionv1.Update}, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, pod, got)
+}
+
+func TestNewDefaultResourcesInvalid(t *testing.T) {
+	for name, params := range map[string]string{
+		"request above limit": `{"defaults":[{"requests":{"cpu":"2"},"limits":{"cpu":"1"}}]}`,
+		"negative":            `{"defaults":[{"requests":{"cpu":"-1"}}]}`,
+		"bad quantity":        `{"defaults":[{"requests":{"cpu":"lots"}}]}`,
+		"bad scope":           `{"defaults":[{"scope":{"namespaces":["["]}}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newDefaultResources(logger(), config.Rule{Name: "default_resources", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/image"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// imageMirror is a container for the mutation rewriting images to pull
+// through mirror registries
+type imageMirror struct {
+	Logger logrus.FieldLogger
+	// Mirrors maps registries or repositories to the mirror their images are
+	// pulled from
+	Mirrors map[string]string
+}
+
+// imageMirror implements the podMutator interface
+var _ podMutator = (*imageMirror)(nil)
+
+// newImageMirror returns an imageMirror configured with the mirrors listed
+// under `mirrors` in the rule params, ie. docker.io: mirror.acme.com/docker
+func newImageMirror(logger logrus.FieldLogger, rule config.Rule) (podMutator, error) {
+	params := struct {
+		Mirrors map[string]string `json:"mirrors"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	for from, to := range params.Mirrors {
+		if from == "" || strings.HasSuffix(from, "/") {
+			return nil, fmt.Errorf("invalid mirrored registry %q in %q", from, rule.Name)
+		}
+		if to == "" || strings.HasSuffix(to, "/") {
+			return nil, fmt.Errorf("invalid mirror %q of %q in %q", to, from, rule.Name)
+		}
+	}
+
+	return imageMirror{Logger: logger, Mirrors: params.Mirrors}, nil
+}
+
+// Name returns the imageMirror short name
+func (im imageMirror) Name() string {
+	return "image_mirror"
+}
+
+// Mutate returns a new mutated pod whose images are rewritten to their
+// mirror, only new pods and pod templates are mutated. The mirror of the
+// longest matching registry or repository is used, images are left untouched
+// if none matches or if they can't be parsed.
+func (im imageMirror) Mutate(ctx context.Context, req *request.Request, pod *corev1.Pod) (*corev1.Pod, error) {
+	im.Logger = req.Log(im.Logger).WithField("mutation", im.Name())
+	mpod := pod.DeepCopy()
+
+	if !req.MutablePod() {
+		return mpod, nil
+	}
+
+	for i := range mpod.Spec.InitContainers {
+		mpod.Spec.InitContainers[i].Image = im.mirror(mpod.Spec.InitContainers[i].Image)
+	}
+	for i := range mpod.Spec.Containers {
+		mpod.Spec.Containers[i].Image = im.mirror(mpod.Spec.Containers[i].Image)
+	}
+	for i := range mpod.Spec.EphemeralContainers {
+		mpod.Spec.EphemeralContainers[i].Image = im.mirror(mpod.Spec.EphemeralContainers[i].Image)
+	}
+
+	return mpod, nil
+}
+
+// mirror returns the image rewritten to its mirror, or the image itself
+func (im imageMirror) mirror(img string) string {
+	ref, err := image.Parse(img)
+	if err != nil {
+		im.Logger.Debugf("not mirroring image %q: %v", img, err)
+		return img
+	}
+
+	var from string
+	for prefix := range im.Mirrors {
+		if ref.HasPrefix(prefix) && len(prefix) > len(from) {
+			from = prefix
+		}
+	}
+	if from == "" {
+		return img
+	}
+
+	mirrored := im.Mirrors[from] + strings.TrimPrefix(ref.String(), from)
+	im.Logger.Debugf("mirroring image %s to %s", img, mirrored)
+	return mirrored
+}
@@ -0,0 +1,77 @@
+package mutation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestImageMirrorMutate(t *testing.T) {
+	rule := config.Rule{
+		Name: "image_mirror",
+		Params: json.RawMessage(`{"mirrors":{
+			"docker.io": "mirror.acme.com/docker",
+			"docker.io/acme": "registry.acme.com/acme",
+			"quay.io": "mirror.acme.com/quay"
+		}}`),
+	}
+	m, err := newImageMirror(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init", Image: "busybox"}},
+		Containers: []corev1.Container{
+			{Name: "nginx", Image: "nginx:1.21"},
+			{Name: "app", Image: "acme/app:v1"},
+			{Name: "prom", Image: "quay.io/prometheus/prometheus@sha256:abcdef"},
+			{Name: "own", Image: "gcr.io/acme/app:v1"},
+			{Name: "bad", Image: "Bad"},
+		},
+	}}
+
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var images []string
+	for _, c := range append(got.Spec.InitContainers, got.Spec.Containers...) {
+		images = append(images, c.Image)
+	}
+	assert.Equal(t, []string{
+		"mirror.acme.com/docker/library/busybox",
+		"mirror.acme.com/docker/library/nginx:1.21",
+		"registry.acme.com/acme/app:v1",
+		"mirror.acme.com/quay/prometheus/prometheus@sha256:abcdef",
+		"gcr.io/acme/app:v1",
+		"Bad",
+	}, images)
+
+	// running pods are left untouched
+	got, err = m.Mutate(context.Background(), &request.Request{Operation: admissionv1.Update}, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, pod, got)
+}
+
+func TestNewImageMirrorInvalid(t *testing.T) {
+	for name, params := range map[string]string{
+		"empty registry": `{"mirrors":{"":"mirror.acme.com"}}`,
+		"empty mirror":   `{"mirrors":{"docker.io":""}}`,
+		"trailing slash": `{"mirrors":{"docker.io":"mirror.acme.com/"}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newImageMirror(logger(), config.Rule{Name: "image_mirror", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
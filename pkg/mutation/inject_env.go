@@ -1,57 +1,235 @@
 package mutation
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"text/template"
+
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
+// defaultEnv is injected when no env vars are configured
+var defaultEnv = []envVar{{EnvVar: corev1.EnvVar{
+	Name:  "KUBE",
+	Value: "true",
+}}}
+
 // injectEnv is a container for the mutation injecting environment vars
 type injectEnv struct {
 	Logger logrus.FieldLogger
+	// Rules are applied in order, defaultEnv is injected in all containers
+	// if there are none
+	Rules []envRule
 }
 
 // injectEnv implements the podMutator interface
 var _ podMutator = (*injectEnv)(nil)
 
+// envRule injects env vars in the containers of the pods it selects
+type envRule struct {
+	// selector matches pod labels, all pods are selected if nil
+	selector labels.Selector
+	// containers are glob patterns matched against container and init
+	// container names, all containers are selected if empty
+	containers []string
+	env        []envVar
+}
+
+// envVar is an env var to inject, its value is either set as in a container
+// spec (value or valueFrom) or rendered from a template
+type envVar struct {
+	corev1.EnvVar
+	// Template is a text/template rendered against the pod metadata, ie.
+	// `{{ .Labels.team }}` or `{{ index .Annotations "acme.com/region" }}`
+	Template string `json:"template,omitempty"`
+
+	tmpl *template.Template
+}
+
+// envData is the data env var templates are rendered against
+type envData struct {
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// newInjectEnv returns an injectEnv configured with the rule params. Env vars
+// listed under `env` are injected in all containers of all pods, `rules` list
+// env vars injected in the containers matching `containers` of the pods
+// matching `selector`. Without params `KUBE=true` is injected.
+func newInjectEnv(logger logrus.FieldLogger, rule config.Rule) (podMutator, error) {
+	type ruleParams struct {
+		Selector   *metav1.LabelSelector `json:"selector"`
+		Containers []string              `json:"containers"`
+		Env        []envVar              `json:"env"`
+	}
+	params := struct {
+		Env   []envVar     `json:"env"`
+		Rules []ruleParams `json:"rules"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	if params.Env == nil && params.Rules == nil {
+		return injectEnv{Logger: logger}, nil
+	}
+
+	all := append([]ruleParams{{Env: params.Env}}, params.Rules...)
+	rules := make([]envRule, 0, len(all))
+	for i, p := range all {
+		r := envRule{containers: p.Containers, env: p.Env}
+
+		if p.Selector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(p.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector in rule %d of %q: %v", i, rule.Name, err)
+			}
+			r.selector = selector
+		}
+		for _, c := range p.Containers {
+			if _, err := path.Match(c, ""); err != nil {
+				return nil, fmt.Errorf("invalid container pattern %q in %q: %v", c, rule.Name, err)
+			}
+		}
+		for j := range r.env {
+			if err := r.env[j].parse(); err != nil {
+				return nil, fmt.Errorf("invalid env var in %q: %v", rule.Name, err)
+			}
+		}
+
+		rules = append(rules, r)
+	}
+
+	return injectEnv{Logger: logger, Rules: rules}, nil
+}
+
 // Name returns the struct name
 func (se injectEnv) Name() string {
 	return "inject_env"
 }
 
-// Mutate returns a new mutated pod according to set env rules
-func (se injectEnv) Mutate(pod *corev1.Pod) (*corev1.Pod, error) {
-	se.Logger = se.Logger.WithField("mutation", se.Name())
+// Mutate returns a new mutated pod according to set env rules, only new pods
+// and pod templates are mutated. Env vars already set in a container are left
+// untouched, templated env vars that fail to render are skipped.
+func (se injectEnv) Mutate(ctx context.Context, req *request.Request, pod *corev1.Pod) (*corev1.Pod, error) {
+	se.Logger = req.Log(se.Logger).WithField("mutation", se.Name())
 	mpod := pod.DeepCopy()
 
-	// build out env var slice
-	envVars := []corev1.EnvVar{{
-		Name:  "KUBE",
-		Value: "true",
-	}}
+	if !req.MutablePod() {
+		return mpod, nil
+	}
+
+	rules := se.Rules
+	if rules == nil {
+		rules = []envRule{{env: defaultEnv}}
+	}
+
+	data := envData{
+		Name:        pod.Name,
+		Namespace:   pod.Namespace,
+		Labels:      pod.Labels,
+		Annotations: pod.Annotations,
+	}
+	if data.Namespace == "" {
+		data.Namespace = req.Namespace
+	}
 
 	// inject env vars into pod
-	for _, envVar := range envVars {
-		se.Logger.Debugf("pod env injected %s", envVar)
-		injectEnvVar(mpod, envVar)
+	for _, r := range rules {
+		if r.selector != nil && !r.selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+
+		for _, v := range r.env {
+			envVar, err := v.render(data)
+			if err != nil {
+				se.Logger.Warnf("env var %s skipped: %v", v.Name, err)
+				continue
+			}
+
+			se.Logger.Debugf("pod env injected %s", envVar)
+			injectEnvVar(mpod, envVar, r.containers)
+		}
 	}
 
 	return mpod, nil
 }
 
+// parse checks the env var and parses its template if any
+func (v *envVar) parse() error {
+	if v.Name == "" {
+		return fmt.Errorf("env var without a name")
+	}
+	if v.Template == "" {
+		return nil
+	}
+	if v.Value != "" || v.ValueFrom != nil {
+		return fmt.Errorf("env var %s has both a template and a value", v.Name)
+	}
+
+	tmpl, err := template.New(v.Name).Option("missingkey=error").Parse(v.Template)
+	if err != nil {
+		return err
+	}
+	v.tmpl = tmpl
+
+	return nil
+}
+
+// render returns the env var to inject, with its template rendered against
+// the given data if any
+func (v envVar) render(data envData) (corev1.EnvVar, error) {
+	if v.tmpl == nil {
+		return v.EnvVar, nil
+	}
+
+	var b bytes.Buffer
+	if err := v.tmpl.Execute(&b, data); err != nil {
+		return corev1.EnvVar{}, err
+	}
+
+	return corev1.EnvVar{Name: v.Name, Value: b.String()}, nil
+}
+
 // injectEnvVar injects a var in both containers and init containers of a pod
-func injectEnvVar(pod *corev1.Pod, envVar corev1.EnvVar) {
+// whose name match one of the given patterns, or in all of them if there are
+// no patterns
+func injectEnvVar(pod *corev1.Pod, envVar corev1.EnvVar, patterns []string) {
 	for i, container := range pod.Spec.Containers {
-		if !HasEnvVar(container, envVar) {
+		if matchContainer(container, patterns) && !HasEnvVar(container, envVar) {
 			pod.Spec.Containers[i].Env = append(container.Env, envVar)
 		}
 	}
 	for i, container := range pod.Spec.InitContainers {
-		if !HasEnvVar(container, envVar) {
+		if matchContainer(container, patterns) && !HasEnvVar(container, envVar) {
 			pod.Spec.InitContainers[i].Env = append(container.Env, envVar)
 		}
 	}
 }
 
+// matchContainer returns true if the container name matches one of the given
+// patterns, or if there are no patterns
+func matchContainer(container corev1.Container, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, container.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // HasEnvVar returns true if environment variable exists false otherwise
 func HasEnvVar(container corev1.Container, checkEnvVar corev1.EnvVar) bool {
 	for _, envVar := range container.Env {
@@ -1,9 +1,14 @@
 package mutation
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
 	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -49,7 +54,7 @@ func TestInjectEnvMutate(t *testing.T) {
 		},
 	}
 
-	got, err := injectEnv{Logger: logger()}.Mutate(pod)
+	got, err := injectEnv{Logger: logger()}.Mutate(context.Background(), createRequest(), pod)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -76,3 +81,131 @@ func TestHasEnvVar(t *testing.T) {
 	assert.True(t, HasEnvVar(c, ey))
 	assert.False(t, HasEnvVar(c, en))
 }
+
+func TestInjectEnvParams(t *testing.T) {
+	rule := config.Rule{
+		Name:   "inject_env",
+		Params: json.RawMessage(`{"env":[{"name":"CLUSTER","value":"kind"}]}`),
+	}
+
+	m, err := newInjectEnv(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "test"}},
+		},
+	}
+
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []corev1.EnvVar{{Name: "CLUSTER", Value: "kind"}}
+	assert.Equal(t, want, got.Spec.Containers[0].Env)
+}
+
+func TestInjectEnvRules(t *testing.T) {
+	rule := config.Rule{
+		Name: "inject_env",
+		Params: json.RawMessage(`{
+			"env": [
+				{"name": "CLUSTER_NAME", "value": "kind"},
+				{"name": "DD_AGENT_HOST", "valueFrom": {"fieldRef": {"fieldPath": "status.hostIP"}}}
+			],
+			"rules": [
+				{
+					"selector": {"matchLabels": {"app": "web"}},
+					"containers": ["app*"],
+					"env": [
+						{"name": "TEAM", "template": "{{ .Labels.team }}"},
+						{"name": "REGION", "template": "{{ index .Annotations \"acme.com/region\" }}-{{ .Namespace }}"},
+						{"name": "DB_PASSWORD", "valueFrom": {"secretKeyRef": {"name": "db", "key": "password"}}}
+					]
+				},
+				{
+					"selector": {"matchLabels": {"app": "db"}},
+					"env": [{"name": "DB", "value": "true"}]
+				}
+			]
+		}`),
+	}
+
+	m, err := newInjectEnv(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Labels:      map[string]string{"app": "web", "team": "infra"},
+			Annotations: map[string]string{"acme.com/region": "us-east-1"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}, {Name: "proxy"}},
+		},
+	}
+
+	got, err := m.Mutate(context.Background(), &request.Request{Operation: admissionv1.Create, Namespace: "apps"}, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	common := []corev1.EnvVar{
+		{Name: "CLUSTER_NAME", Value: "kind"},
+		{Name: "DD_AGENT_HOST", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"},
+		}},
+	}
+	app := append(common,
+		corev1.EnvVar{Name: "TEAM", Value: "infra"},
+		corev1.EnvVar{Name: "REGION", Value: "us-east-1-apps"},
+		corev1.EnvVar{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+				Key:                  "password",
+			},
+		}},
+	)
+	assert.Equal(t, app, got.Spec.Containers[0].Env)
+	assert.Equal(t, common, got.Spec.Containers[1].Env)
+}
+
+func TestInjectEnvMissingTemplateKey(t *testing.T) {
+	rule := config.Rule{
+		Name:   "inject_env",
+		Params: json.RawMessage(`{"env":[{"name":"TEAM","template":"{{ .Labels.team }}"},{"name":"KUBE","value":"true"}]}`),
+	}
+
+	m, err := newInjectEnv(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// vars whose template can't be rendered are skipped
+	assert.Equal(t, []corev1.EnvVar{{Name: "KUBE", Value: "true"}}, got.Spec.Containers[0].Env)
+}
+
+func TestNewInjectEnvInvalid(t *testing.T) {
+	for name, params := range map[string]string{
+		"no name":            `{"env":[{"value":"true"}]}`,
+		"template and value": `{"env":[{"name":"A","value":"a","template":"b"}]}`,
+		"bad template":       `{"env":[{"name":"A","template":"{{ .Labels"}]}`,
+		"bad selector":       `{"rules":[{"selector":{"matchExpressions":[{"key":"a","operator":"Nope"}]}}]}`,
+		"bad container":      `{"rules":[{"containers":["["]}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newInjectEnv(logger(), config.Rule{Name: "inject_env", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// defaultSidecarAnnotation lists, comma separated, the sidecar templates to
+// inject in a pod
+const defaultSidecarAnnotation = "acme.com/inject-sidecars"
+
+// Positions of injected init containers relative to the pod init containers
+const (
+	initFirst = "first"
+	initLast  = "last"
+)
+
+// injectSidecar is a container for the mutation injecting sidecar containers
+type injectSidecar struct {
+	Logger     logrus.FieldLogger
+	Annotation string
+	Templates  []sidecarTemplate
+}
+
+// injectSidecar implements the podMutator interface
+var _ podMutator = (*injectSidecar)(nil)
+
+// sidecarTemplate is a named set of containers, init containers and volumes
+// injected together
+type sidecarTemplate struct {
+	Name string `json:"name"`
+	// Selector injects the template in the pods whose labels it matches, on
+	// top of the pods requesting it by annotation
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// InitPosition sets whether init containers run before (first, default)
+	// or after (last) the pod init containers
+	InitPosition   string             `json:"initPosition,omitempty"`
+	Containers     []corev1.Container `json:"containers,omitempty"`
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+	Volumes        []corev1.Volume    `json:"volumes,omitempty"`
+
+	selector labels.Selector
+}
+
+// newInjectSidecar returns an injectSidecar configured with the rule params,
+// templates are listed under `templates` and pods request them with the
+// annotation set in `annotation`, acme.com/inject-sidecars by default
+func newInjectSidecar(logger logrus.FieldLogger, rule config.Rule) (podMutator, error) {
+	params := struct {
+		Annotation string            `json:"annotation"`
+		Templates  []sidecarTemplate `json:"templates"`
+	}{
+		Annotation: defaultSidecarAnnotation,
+	}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for i := range params.Templates {
+		t := &params.Templates[i]
+		if t.Name == "" {
+			return nil, fmt.Errorf("sidecar template without a name in %q", rule.Name)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("sidecar template %q is defined more than once in %q", t.Name, rule.Name)
+		}
+		names[t.Name] = true
+
+		if err := t.check(); err != nil {
+			return nil, fmt.Errorf("invalid sidecar template %q in %q: %v", t.Name, rule.Name, err)
+		}
+	}
+
+	return injectSidecar{Logger: logger, Annotation: params.Annotation, Templates: params.Templates}, nil
+}
+
+// check defaults unset fields, parses the selector and returns an error if
+// the template is invalid
+func (t *sidecarTemplate) check() error {
+	switch t.InitPosition {
+	case "":
+		t.InitPosition = initFirst
+	case initFirst, initLast:
+	default:
+		return fmt.Errorf("unknown init position %q, must be %q or %q", t.InitPosition, initFirst, initLast)
+	}
+
+	for _, containers := range [][]corev1.Container{t.Containers, t.InitContainers} {
+		for _, c := range containers {
+			if c.Name == "" {
+				return fmt.Errorf("container without a name")
+			}
+		}
+	}
+	for _, v := range t.Volumes {
+		if v.Name == "" {
+			return fmt.Errorf("volume without a name")
+		}
+	}
+
+	if t.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(t.Selector)
+		if err != nil {
+			return err
+		}
+		t.selector = selector
+	}
+
+	return nil
+}
+
+// Name returns the struct name
+func (si injectSidecar) Name() string {
+	return "inject_sidecar"
+}
+
+// Mutate returns a new mutated pod with the sidecar templates it requests or
+// is selected by, only new pods and pod templates are mutated. Containers,
+// init containers and volumes are skipped if the pod already has one of the
+// same name, so that injecting twice changes nothing.
+func (si injectSidecar) Mutate(ctx context.Context, req *request.Request, pod *corev1.Pod) (*corev1.Pod, error) {
+	si.Logger = req.Log(si.Logger).WithField("mutation", si.Name())
+	mpod := pod.DeepCopy()
+
+	if !req.MutablePod() {
+		return mpod, nil
+	}
+
+	requested := map[string]bool{}
+	for _, name := range strings.Split(pod.Annotations[si.Annotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			requested[name] = true
+		}
+	}
+
+	for _, t := range si.Templates {
+		selected := t.selector != nil && t.selector.Matches(labels.Set(pod.Labels))
+		if !requested[t.Name] && !selected {
+			continue
+		}
+		delete(requested, t.Name)
+
+		si.Logger.Debugf("injecting sidecar template %s", t.Name)
+		injectTemplate(mpod, t)
+	}
+
+	for name := range requested {
+		si.Logger.Warnf("unknown sidecar template %s requested", name)
+	}
+
+	return mpod, nil
+}
+
+// injectTemplate injects copies of the containers, init containers and
+// volumes of the template the pod doesn't have yet
+func injectTemplate(pod *corev1.Pod, t sidecarTemplate) {
+	for _, c := range t.Containers {
+		if !hasContainer(pod.Spec.Containers, c.Name) {
+			pod.Spec.Containers = append(pod.Spec.Containers, *c.DeepCopy())
+		}
+	}
+
+	var inits []corev1.Container
+	for _, c := range t.InitContainers {
+		if !hasContainer(pod.Spec.InitContainers, c.Name) {
+			inits = append(inits, *c.DeepCopy())
+		}
+	}
+	if len(inits) > 0 {
+		if t.InitPosition == initLast {
+			pod.Spec.InitContainers = append(pod.Spec.InitContainers, inits...)
+		} else {
+			pod.Spec.InitContainers = append(inits, pod.Spec.InitContainers...)
+		}
+	}
+
+	for _, v := range t.Volumes {
+		if !hasVolume(pod.Spec.Volumes, v.Name) {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, *v.DeepCopy())
+		}
+	}
+}
+
+// hasContainer returns true if a container of the given name exists
+func hasContainer(containers []corev1.Container, name string) bool {
+	for _, c := range containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVolume returns true if a volume of the given name exists
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, v := range volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package mutation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sidecarRule returns an inject_sidecar rule with a log shipper template
+// requested by annotation and a proxy template selected by label
+func sidecarRule() config.Rule {
+	return config.Rule{
+		Name: "inject_sidecar",
+		Params: json.RawMessage(`{"templates":[
+			{
+				"name": "log-shipper",
+				"containers": [{"name": "log-shipper", "image": "fluent-bit",
+					"volumeMounts": [{"name": "logs", "mountPath": "/var/log/app"}]}],
+				"volumes": [{"name": "logs", "emptyDir": {}}]
+			},
+			{
+				"name": "proxy",
+				"selector": {"matchLabels": {"mesh": "enabled"}},
+				"containers": [{"name": "proxy", "image": "envoy"}],
+				"initContainers": [{"name": "proxy-init", "image": "proxy-init"}]
+			}
+		]}`),
+	}
+}
+
+func TestInjectSidecarMutate(t *testing.T) {
+	m, err := newInjectSidecar(logger(), sidecarRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Labels:      map[string]string{"mesh": "enabled"},
+			Annotations: map[string]string{"acme.com/inject-sidecars": "log-shipper"},
+		},
+		Spec: corev1.PodSpec{
+			Containers:     []corev1.Container{{Name: "app"}},
+			InitContainers: []corev1.Container{{Name: "migrate"}},
+		},
+	}
+
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var containers, inits, volumes []string
+	for _, c := range got.Spec.Containers {
+		containers = append(containers, c.Name)
+	}
+	for _, c := range got.Spec.InitContainers {
+		inits = append(inits, c.Name)
+	}
+	for _, v := range got.Spec.Volumes {
+		volumes = append(volumes, v.Name)
+	}
+	assert.Equal(t, []string{"app", "log-shipper", "proxy"}, containers)
+	assert.Equal(t, []string{"proxy-init", "migrate"}, inits)
+	assert.Equal(t, []string{"logs"}, volumes)
+
+	// injecting again changes nothing
+	again, err := m.Mutate(context.Background(), createRequest(), got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got, again)
+}
+
+func TestInjectSidecarNotRequested(t *testing.T) {
+	m, err := newInjectSidecar(logger(), sidecarRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Annotations: map[string]string{"acme.com/inject-sidecars": "unknown"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, pod, got)
+}
+
+func TestInjectSidecarInitLast(t *testing.T) {
+	rule := config.Rule{
+		Name: "inject_sidecar",
+		Params: json.RawMessage(`{"annotation":"sidecars","templates":[{
+			"name": "warmup",
+			"initPosition": "last",
+			"initContainers": [{"name": "warmup"}]
+		}]}`),
+	}
+	m, err := newInjectSidecar(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{"sidecars": "warmup"}},
+		Spec:       corev1.PodSpec{InitContainers: []corev1.Container{{Name: "migrate"}}},
+	}
+
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []corev1.Container{{Name: "migrate"}, {Name: "warmup"}}, got.Spec.InitContainers)
+}
+
+func TestInjectSidecarPatch(t *testing.T) {
+	m, err := NewMutator(logger(), &config.Config{Mutators: []config.Rule{sidecarRule()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Labels: map[string]string{"mesh": "enabled"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+
+	got, err := m.MutatePodPatch(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `[
+		{"op":"add","path":"/spec/containers/-","value":{"name":"proxy","image":"envoy","resources":{}}},
+		{"op":"add","path":"/spec/initContainers","value":[{"name":"proxy-init","image":"proxy-init","resources":{}}]}
+	]`, string(got))
+}
+
+func TestNewInjectSidecarInvalid(t *testing.T) {
+	for name, params := range map[string]string{
+		"no name":              `{"templates":[{"containers":[{"name":"a"}]}]}`,
+		"duplicated name":      `{"templates":[{"name":"a"},{"name":"a"}]}`,
+		"unnamed container":    `{"templates":[{"name":"a","containers":[{"image":"a"}]}]}`,
+		"unnamed volume":       `{"templates":[{"name":"a","volumes":[{"emptyDir":{}}]}]}`,
+		"bad init position":    `{"templates":[{"name":"a","initPosition":"middle"}]}`,
+		"bad selector":         `{"templates":[{"name":"a","selector":{"matchExpressions":[{"key":"a","operator":"Nope"}]}}]}`,
+		"unknown template key": `{"templates":[{"name":"a","sidecars":[]}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newInjectSidecar(logger(), config.Rule{Name: "inject_sidecar", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
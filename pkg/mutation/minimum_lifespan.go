@@ -1,72 +1,92 @@
 package mutation
 
 import (
-	"fmt"
+	"context"
 	"reflect"
-	"strconv"
 
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/lifespan"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
 	corev1 "k8s.io/api/core/v1"
 )
 
 // minLifespanTolerations is a container for mininum lifespan mutation
 type minLifespanTolerations struct {
 	Logger logrus.FieldLogger
+	// Families are the lifespan taint families pods are given tolerations
+	// for
+	Families []lifespan.Family
 }
 
 // minLifespanTolerations implements the podMutator interface
 var _ podMutator = (*minLifespanTolerations)(nil)
 
+// newMinLifespanTolerations returns a minLifespanTolerations configured with
+// the lifespan families listed under `families` in the rule params
+func newMinLifespanTolerations(logger logrus.FieldLogger, rule config.Rule) (podMutator, error) {
+	families, err := lifespan.Families(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	return minLifespanTolerations{Logger: logger, Families: families}, nil
+}
+
 // Name returns the minLifespanTolerations short name
 func (mpl minLifespanTolerations) Name() string {
-	return "min_lifespan"
+	return lifespan.MutationName
 }
 
-// Mutate returns a new mutated pod according to lifespan tolerations rules
-func (mpl minLifespanTolerations) Mutate(pod *corev1.Pod) (*corev1.Pod, error) {
-	const (
-		lifespanLabel = "acme.com/lifespan-requested"
-		taintKey      = "acme.com/lifespan-remaining"
-		taintMaxAge   = 14
-	)
-
-	mpl.Logger = mpl.Logger.WithField("mutation", mpl.Name())
+// Mutate returns a new mutated pod according to lifespan tolerations rules,
+// only new pods and pod templates are mutated. Each family is handled on its
+// own, no toleration is given for families whose label is invalid: such pods
+// are denied by the lifespan_label validator.
+func (mpl minLifespanTolerations) Mutate(ctx context.Context, req *request.Request, pod *corev1.Pod) (*corev1.Pod, error) {
+	mpl.Logger = req.Log(mpl.Logger).WithField("mutation", mpl.Name())
 	mpod := pod.DeepCopy()
 
-	if pod.Labels == nil || pod.Labels[lifespanLabel] == "" {
-		mpl.Logger.WithField("min_lifespan", 0).
-			Printf("no lifespan label found, applying default lifespan toleration")
-
-		tn := []corev1.Toleration{{
-			Key:      taintKey,
-			Operator: corev1.TolerationOpExists,
-			Effect:   corev1.TaintEffectNoSchedule,
-		}}
-
-		mpod.Spec.Tolerations = appendTolerations(tn, mpod.Spec.Tolerations)
+	if !req.MutablePod() {
 		return mpod, nil
 	}
 
-	ts := pod.Labels[lifespanLabel]
-	minAge, err := strconv.Atoi(ts)
-	if err != nil {
-		return nil, fmt.Errorf("pod lifespan label %q is not an integer: %v", ts, err)
+	for _, l := range lifespan.Labels(mpl.Families, pod.Labels) {
+		mpod.Spec.Tolerations = appendTolerations(mpl.tolerations(l), mpod.Spec.Tolerations)
 	}
 
-	mpl.Logger.WithField("min_lifespan", ts).Printf("setting lifespan tolerations")
+	return mpod, nil
+}
 
-	t := []corev1.Toleration{}
-	for i := taintMaxAge; i >= minAge; i-- {
-		t = append(t, corev1.Toleration{
-			Key:      taintKey,
-			Operator: corev1.TolerationOpEqual,
-			Effect:   corev1.TaintEffectNoSchedule,
-			Value:    fmt.Sprint(i),
-		})
+// tolerations returns the tolerations of a family for the lifespan label of a
+// pod
+func (mpl minLifespanTolerations) tolerations(l lifespan.Label) []corev1.Toleration {
+	f, ts := l.Family, l.Value
+	log := mpl.Logger.WithField("taint_key", f.TaintKey)
+
+	if ts == "" {
+		switch f.Unlabelled {
+		case lifespan.UnlabelledNone:
+			log.Debug("no lifespan label found, no toleration applied")
+			return nil
+		case lifespan.UnlabelledDefault:
+			log.WithField("min_lifespan", f.DefaultLifespan).
+				Printf("no lifespan label found, applying default lifespan tolerations")
+			return f.Tolerations(f.DefaultLifespan)
+		}
+
+		log.WithField("min_lifespan", 0).
+			Printf("no lifespan label found, applying default lifespan toleration")
+		return f.AnyTolerations()
 	}
 
-	mpod.Spec.Tolerations = appendTolerations(t, mpod.Spec.Tolerations)
-	return mpod, nil
+	minAge, err := f.Lifespan(ts)
+	if err != nil {
+		log.Warnf("no lifespan toleration applied: %v", err)
+		return nil
+	}
+
+	log.WithField("min_lifespan", ts).Printf("setting lifespan tolerations")
+	return f.Tolerations(minAge)
 }
 
 // appendTolerations appends existing to new without duplicating any tolerations
@@ -1,13 +1,22 @@
 package mutation
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/lifespan"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// defaultMinLifespan returns the min_lifespan mutation of the default family
+func defaultMinLifespan() minLifespanTolerations {
+	return minLifespanTolerations{Logger: logger(), Families: []lifespan.Family{lifespan.Default()}}
+}
+
 func TestMinLifespanTolerationsNoLabel(t *testing.T) {
 	want := &corev1.Pod{
 		ObjectMeta: v1.ObjectMeta{
@@ -40,7 +49,7 @@ func TestMinLifespanTolerationsNoLabel(t *testing.T) {
 		},
 	}
 
-	got, err := minLifespanTolerations{logger()}.Mutate(pod)
+	got, err := defaultMinLifespan().Mutate(context.Background(), createRequest(), pod)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -140,7 +149,7 @@ func TestMinLifespanTolerationsLabel(t *testing.T) {
 			},
 		},
 	}
-	got, err := minLifespanTolerations{logger()}.Mutate(pod)
+	got, err := defaultMinLifespan().Mutate(context.Background(), createRequest(), pod)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -172,10 +181,59 @@ func TestMinLifespanTolerationsIdempotence(t *testing.T) {
 		},
 	}
 
-	got, err := minLifespanTolerations{logger()}.Mutate(want.DeepCopy())
+	got, err := defaultMinLifespan().Mutate(context.Background(), createRequest(), want.DeepCopy())
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	assert.Equal(t, want, got)
 }
+
+func TestMinLifespanTolerationsFamilies(t *testing.T) {
+	rule := config.Rule{
+		Name: "min_lifespan",
+		Params: json.RawMessage(`{"families":[
+			{"labelKey":"acme.com/spot-lifespan","taintKey":"acme.com/spot-remaining","maxAge":30,"minAge":28},
+			{"labelKey":"acme.com/lifespan","taintKey":"acme.com/remaining","maxAge":30,
+			 "unlabelled":"default","defaultLifespan":29,"effects":["NoExecute"]},
+			{"labelKey":"acme.com/gpu-lifespan","taintKey":"acme.com/gpu-remaining","maxAge":30,"unlabelled":"none"}
+		]}`),
+	}
+
+	m, err := newMinLifespanTolerations(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+		Labels: map[string]string{"acme.com/spot-lifespan": "29"},
+	}}
+
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []corev1.Toleration{
+		{Key: "acme.com/spot-remaining", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoSchedule, Value: "30"},
+		{Key: "acme.com/spot-remaining", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoSchedule, Value: "29"},
+		{Key: "acme.com/remaining", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoExecute, Value: "30"},
+		{Key: "acme.com/remaining", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoExecute, Value: "29"},
+	}
+	assert.Equal(t, want, got.Spec.Tolerations)
+}
+
+func TestMinLifespanTolerationsInvalidLabel(t *testing.T) {
+	for _, value := range []string{"seven", "-1", "15"} {
+		t.Run(value, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+				Labels: map[string]string{"acme.com/lifespan-requested": value},
+			}}
+
+			// invalid labels are denied by the lifespan_label validator
+			got, err := defaultMinLifespan().Mutate(context.Background(), createRequest(), pod)
+			assert.Nil(t, err)
+			assert.Empty(t, got.Spec.Tolerations)
+		})
+	}
+}
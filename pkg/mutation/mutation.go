@@ -1,65 +1,239 @@
 package mutation
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/deadline"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/exemption"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/metrics"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/scope"
 	"github.com/wI2L/jsondiff"
 	corev1 "k8s.io/api/core/v1"
 )
 
 // Mutator is a container for mutation
 type Mutator struct {
-	Logger *logrus.Entry
+	Logger     *logrus.Entry
+	mutations  []rule
+	exemptions *exemption.Policy
 }
 
-// NewMutator returns an initialised instance of Mutator
-func NewMutator(logger *logrus.Entry) *Mutator {
-	return &Mutator{Logger: logger}
+// NewMutator returns an initialised instance of Mutator applying the mutators
+// enabled in cfg, in order
+func NewMutator(logger *logrus.Entry, cfg *config.Config) (*Mutator, error) {
+	m := &Mutator{Logger: logger, exemptions: exemption.New(cfg.Exemptions)}
+
+	for _, r := range cfg.Mutators {
+		newMutation, ok := mutations[r.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown mutator %q", r.Name)
+		}
+
+		pm, err := newMutation(logger, r)
+		if err != nil {
+			return nil, err
+		}
+		sc, err := scope.New(r.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope for %q: %v", r.Name, err)
+		}
+		m.mutations = append(m.mutations, rule{podMutator: pm, mode: r.Enforcement(), scope: sc})
+	}
+
+	return m, nil
 }
 
-// podMutators is an interface used to group functions mutating pods
+// WithLogger returns a copy of the mutator logging to the given logger, so
+// that a mutator built once can log the fields of each admission request.
+// Mutations are passed the logger along with the request.
+func (m *Mutator) WithLogger(logger *logrus.Entry) *Mutator {
+	c := *m
+	c.Logger = logger
+	return &c
+}
+
+// NamespaceSelectors returns true if a mutation, or a scope within its params,
+// is scoped by namespace selector, namespace labels then have to be looked up
+func (m *Mutator) NamespaceSelectors() bool {
+	for _, r := range m.mutations {
+		scopes := []*scope.Scope{r.scope}
+		if p, ok := r.podMutator.(scopedParams); ok {
+			scopes = append(scopes, p.paramScopes()...)
+		}
+		for _, s := range scopes {
+			if s.NamespaceSelector() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// podMutators is an interface used to group functions mutating pods, the
+// admission request attributes are passed along with the pod. The context
+// is done once the admission request times out, mutators blocking on anything
+// must then return, see deadline.Run.
 type podMutator interface {
-	Mutate(*corev1.Pod) (*corev1.Pod, error)
+	Mutate(context.Context, *request.Request, *corev1.Pod) (*corev1.Pod, error)
 	Name() string
 }
 
+// rule is a configured podMutator along with its enforcement mode and scope
+type rule struct {
+	podMutator
+	mode  config.Mode
+	scope *scope.Scope
+}
+
+// scopedParams is implemented by mutators whose params hold scopes of their
+// own, ie. default_resources
+type scopedParams interface {
+	paramScopes() []*scope.Scope
+}
+
+// mutations lists all known pod mutators by name, each entry builds a mutator
+// from its config rule
+var mutations = map[string]func(logrus.FieldLogger, config.Rule) (podMutator, error){
+	"min_lifespan":      newMinLifespanTolerations,
+	"inject_env":        newInjectEnv,
+	"inject_sidecar":    newInjectSidecar,
+	"default_resources": newDefaultResources,
+	"image_mirror":      newImageMirror,
+	"security_context":  newSecurityContext,
+}
+
+// Result is the outcome of mutating a pod
+type Result struct {
+	// Pod is a mutated copy of the original pod
+	Pod *corev1.Pod
+	// Applied lists the mutations that changed the pod, in order
+	Applied []string
+	// Warnings are surfaced to the client for mutations in warn mode that
+	// would have changed the pod
+	Warnings []string
+}
+
 // MutatePodPatch returns a json patch containing all the mutations needed for
 // a given pod
-func (m *Mutator) MutatePodPatch(pod *corev1.Pod) ([]byte, error) {
-	var podName string
-	if pod.Name != "" {
-		podName = pod.Name
-	} else {
-		if pod.ObjectMeta.GenerateName != "" {
-			podName = pod.ObjectMeta.GenerateName
-		}
+func (m *Mutator) MutatePodPatch(ctx context.Context, req *request.Request, pod *corev1.Pod) ([]byte, error) {
+	res, err := m.MutatePod(ctx, req, pod)
+	if err != nil {
+		return nil, err
 	}
-	log := logrus.WithField("pod_name", podName)
 
-	// list of all mutations to be applied to the pod
-	mutations := []podMutator{
-		minLifespanTolerations{Logger: log},
-		injectEnv{Logger: log},
-	}
+	return CreatePatch(pod, res.Pod)
+}
 
-	mpod := pod.DeepCopy()
+// MutatePod returns a mutated copy of the given pod with all mutations applied,
+// along with the names of the mutations that changed it. Mutations in warn or
+// dry-run mode are evaluated but not applied, their errors are logged rather
+// than returned, including their timeouts. Mutations are skipped for pods out
+// of their scope, scopes are matched against the given pod, and for pods
+// exempted from them. An error wrapping ctx.Err() is returned if ctx is done
+// before all enforced mutations complete.
+func (m *Mutator) MutatePod(ctx context.Context, req *request.Request, pod *corev1.Pod) (Result, error) {
+	res := Result{Pod: pod.DeepCopy()}
+	req = req.WithLogger(m.Logger)
+	exemptions, warnings := m.exemptions.Exempted(req, pod)
+	res.Warnings = append(res.Warnings, warnings...)
 
 	// apply all mutations
-	for _, m := range mutations {
-		var err error
-		mpod, err = m.Mutate(mpod)
+	for _, r := range m.mutations {
+		inScope, err := r.scope.Matches(req, pod)
 		if err != nil {
-			return nil, err
+			err = fmt.Errorf("could not match scope of %q: %v", r.Name(), err)
+			if m.skipError(r, err) {
+				continue
+			}
+			return Result{}, err
+		}
+		if !inScope {
+			m.Logger.WithField("mutation", r.Name()).Trace("pod out of scope")
+			continue
+		}
+
+		if exemptions.Exempts(r.Name()) {
+			m.Logger.WithField("mutation", r.Name()).WithField("user", req.UserInfo.Username).
+				Info("pod exempted from mutation")
+			metrics.RuleExemptions.WithLabelValues(metrics.KindMutator, r.Name()).Inc()
+			continue
+		}
+
+		// the results of a mutation still running past the deadline are
+		// never read
+		var mpod *corev1.Pod
+		var mutateErr error
+		start := time.Now()
+		err = deadline.Run(ctx, fmt.Sprintf("mutation %q", r.Name()), func() {
+			mpod, mutateErr = r.Mutate(ctx, req, res.Pod)
+		})
+		metrics.ObserveRule(metrics.KindMutator, r.Name(), start)
+		if err != nil {
+			if m.skipError(r, err) {
+				continue
+			}
+			return Result{}, err
+		}
+		if mutateErr != nil {
+			if m.skipError(r, mutateErr) {
+				continue
+			}
+			return Result{}, mutateErr
 		}
+
+		if reflect.DeepEqual(res.Pod, mpod) {
+			continue
+		}
+
+		log := m.Logger.WithField("mutation", r.Name())
+		metrics.RuleTriggers.WithLabelValues(metrics.KindMutator, r.Name(), string(r.mode)).Inc()
+		switch r.mode {
+		case config.ModeWarn:
+			log.Debug("mutation in warn mode skipped")
+			res.Warnings = append(res.Warnings, fmt.Sprintf("%s: mutation would change the pod", r.Name()))
+		case config.ModeDryRun:
+			log.Info("mutation in dry-run mode skipped")
+		default:
+			res.Applied = append(res.Applied, r.Name())
+			res.Pod = mpod
+		}
+	}
+
+	return res, nil
+}
+
+// skipError counts the error of a mutation and returns true if the mutation
+// is not enforced, the error is then logged and the pod left as if the
+// mutation had not run
+func (m *Mutator) skipError(r rule, err error) bool {
+	metrics.RuleErrors.WithLabelValues(metrics.KindMutator, r.Name(), string(r.mode)).Inc()
+	if r.mode == config.ModeEnforce {
+		return false
 	}
 
-	// generate json patch
-	patch, err := jsondiff.Compare(pod, mpod)
+	m.Logger.WithField("mutation", r.Name()).Warnf("mutation in %s mode failed: %v", r.mode, err)
+	return true
+}
+
+// CreatePatch returns a json patch turning the original object into the
+// mutated one
+func CreatePatch(original, mutated interface{}) ([]byte, error) {
+	patch, err := jsondiff.Compare(original, mutated)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, op := range patch {
+		metrics.PatchOperations.WithLabelValues(op.Type).Inc()
+	}
+
 	patchb, err := json.Marshal(patch)
 	if err != nil {
 		return nil, err
@@ -1,19 +1,34 @@
 package mutation
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/metrics"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
 	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestMutatePodPatch(t *testing.T) {
-	m := NewMutator(logger())
-	got, err := m.MutatePodPatch(pod())
+	m, err := NewMutator(logger(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.MutatePodPatch(context.Background(), createRequest(), pod())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -24,11 +39,14 @@ func TestMutatePodPatch(t *testing.T) {
 }
 
 func BenchmarkMutatePodPatch(b *testing.B) {
-	m := NewMutator(logger())
+	m, err := NewMutator(logger(), config.Default())
+	if err != nil {
+		b.Fatal(err)
+	}
 	pod := pod()
 
 	for i := 0; i < b.N; i++ {
-		_, err := m.MutatePodPatch(pod)
+		_, err := m.MutatePodPatch(context.Background(), createRequest(), pod)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -76,8 +94,342 @@ func patch() string {
 	return patch
 }
 
+// createRequest returns the attributes of a CREATE admission request
+func createRequest() *request.Request {
+	return &request.Request{Operation: admissionv1.Create}
+}
+
 func logger() *logrus.Entry {
 	mute := logrus.StandardLogger()
 	mute.Out = ioutil.Discard
 	return mute.WithField("logger", "test")
 }
+
+func TestNewMutator(t *testing.T) {
+	t.Run("unknown mutator", func(t *testing.T) {
+		cfg := &config.Config{Mutators: []config.Rule{{Name: "nope"}}}
+		_, err := NewMutator(logger(), cfg)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		cfg := &config.Config{Mutators: []config.Rule{{
+			Name:   "min_lifespan",
+			Params: json.RawMessage(`{"foo":"bar"}`),
+		}}}
+		_, err := NewMutator(logger(), cfg)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid scope", func(t *testing.T) {
+		cfg := &config.Config{Mutators: []config.Rule{{
+			Name:  "min_lifespan",
+			Scope: &config.Scope{Namespaces: []string{"["}},
+		}}}
+		_, err := NewMutator(logger(), cfg)
+		assert.Error(t, err)
+	})
+
+	t.Run("ordered mutators", func(t *testing.T) {
+		cfg := &config.Config{Mutators: []config.Rule{
+			{Name: "inject_env"},
+			{Name: "min_lifespan"},
+		}}
+		m, err := NewMutator(logger(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, m.mutations, 2)
+		assert.Equal(t, "inject_env", m.mutations[0].Name())
+		assert.Equal(t, "min_lifespan", m.mutations[1].Name())
+	})
+}
+
+func TestMutatePodPatchMetrics(t *testing.T) {
+	m, err := NewMutator(logger(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.ToFloat64(metrics.PatchOperations.WithLabelValues("add"))
+	if _, err := m.MutatePodPatch(context.Background(), createRequest(), pod()); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.PatchOperations.WithLabelValues("add")))
+}
+
+func TestMutatePod(t *testing.T) {
+	m, err := NewMutator(logger(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := pod()
+	got, err := m.MutatePod(context.Background(), createRequest(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the given pod is left untouched
+	assert.Equal(t, pod(), p)
+	assert.Len(t, got.Pod.Spec.Tolerations, 8)
+	assert.Equal(t, []corev1.EnvVar{{Name: "KUBE", Value: "true"}}, got.Pod.Spec.Containers[0].Env)
+	assert.Equal(t, []string{"min_lifespan", "inject_env"}, got.Applied)
+
+	// mutating an already mutated pod applies nothing
+	again, err := m.MutatePod(context.Background(), createRequest(), got.Pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, again.Applied)
+}
+
+func TestMutatePodRequestLogger(t *testing.T) {
+	m, err := NewMutator(logger(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, hook := test.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+	_, err = m.WithLogger(log.WithField("pod_name", "lifespan")).MutatePod(context.Background(), createRequest(), pod())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mutations built once log with the fields of the admission request
+	var mutations []interface{}
+	for _, e := range hook.AllEntries() {
+		if e.Data["pod_name"] == "lifespan" {
+			mutations = append(mutations, e.Data["mutation"])
+		}
+	}
+	assert.Contains(t, mutations, "inject_env")
+	assert.Contains(t, mutations, "min_lifespan")
+}
+
+// slowMutator is a mutator only returning once released
+type slowMutator struct{ release chan struct{} }
+
+func (slowMutator) Name() string { return "slow" }
+
+func (s slowMutator) Mutate(_ context.Context, _ *request.Request, pod *corev1.Pod) (*corev1.Pod, error) {
+	<-s.release
+	return pod.DeepCopy(), nil
+}
+
+func TestMutatePodTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	m := &Mutator{
+		Logger:    logger(),
+		mutations: []rule{{podMutator: slowMutator{release: release}, mode: config.ModeEnforce}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := m.MutatePod(ctx, createRequest(), pod())
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.EqualError(t, err, `mutation "slow" did not complete: context deadline exceeded`)
+
+	// no mutation is run once the deadline passed
+	_, err = m.MutatePod(ctx, createRequest(), pod())
+	assert.EqualError(t, err, `mutation "slow" not run: context deadline exceeded`)
+}
+
+func TestMutatePodDryRunTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	m := &Mutator{
+		Logger:    logger(),
+		mutations: []rule{{podMutator: slowMutator{release: release}, mode: config.ModeDryRun}},
+	}
+	before := testutil.ToFloat64(metrics.RuleErrors.WithLabelValues(metrics.KindMutator, "slow", string(config.ModeDryRun)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	got, err := m.MutatePod(ctx, createRequest(), pod())
+	assert.Nil(t, err)
+	assert.Empty(t, got.Applied)
+	assert.Equal(t, pod(), got.Pod)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.RuleErrors.WithLabelValues(metrics.KindMutator, "slow", string(config.ModeDryRun))))
+}
+
+func TestMutatePodModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode     config.Mode
+		applied  []string
+		warnings []string
+	}{
+		{config.ModeEnforce, []string{"inject_env"}, nil},
+		{config.ModeWarn, nil, []string{"inject_env: mutation would change the pod"}},
+		{config.ModeDryRun, nil, nil},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			cfg := &config.Config{Mutators: []config.Rule{{Name: "inject_env", Mode: tc.mode}}}
+			m, err := NewMutator(logger(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := m.MutatePod(context.Background(), createRequest(), pod())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tc.applied, got.Applied)
+			assert.Equal(t, tc.warnings, got.Warnings)
+			if tc.applied == nil {
+				assert.Equal(t, pod(), got.Pod)
+			}
+		})
+	}
+}
+
+// failingMutator is a mutator always returning an error
+type failingMutator struct{}
+
+func (failingMutator) Name() string { return "failing" }
+
+func (failingMutator) Mutate(context.Context, *request.Request, *corev1.Pod) (*corev1.Pod, error) {
+	return nil, errors.New("broken")
+}
+
+func TestMutatePodModesError(t *testing.T) {
+	for _, tc := range []struct {
+		mode config.Mode
+		err  bool
+	}{
+		{config.ModeEnforce, true},
+		{config.ModeWarn, false},
+		{config.ModeDryRun, false},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			env, err := newInjectEnv(logger(), config.Rule{Name: "inject_env"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &Mutator{
+				Logger: logger(),
+				mutations: []rule{
+					{podMutator: failingMutator{}, mode: tc.mode},
+					{podMutator: env, mode: config.ModeEnforce},
+				},
+			}
+			before := testutil.ToFloat64(metrics.RuleErrors.WithLabelValues(metrics.KindMutator, "failing", string(tc.mode)))
+
+			got, err := m.MutatePod(context.Background(), createRequest(), pod())
+			assert.Equal(t, before+1, testutil.ToFloat64(metrics.RuleErrors.WithLabelValues(metrics.KindMutator, "failing", string(tc.mode))))
+			if tc.err {
+				assert.EqualError(t, err, "broken")
+				return
+			}
+
+			// the following enforced mutations are still applied
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"inject_env"}, got.Applied)
+		})
+	}
+}
+
+func TestMutatePodUpdate(t *testing.T) {
+	m, err := NewMutator(logger(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pod specs are mostly immutable so running pods are left alone
+	req := &request.Request{Operation: admissionv1.Update, OldObject: pod()}
+	got, err := m.MutatePod(context.Background(), req, pod())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, got.Applied)
+	assert.Equal(t, pod(), got.Pod)
+
+	// pod templates are mutated on update as they only affect new pods
+	req.Template = true
+	got, err = m.MutatePod(context.Background(), req, pod())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"min_lifespan", "inject_env"}, got.Applied)
+}
+
+func TestMutatePodScope(t *testing.T) {
+	cfg := &config.Config{Mutators: []config.Rule{
+		{Name: "min_lifespan", Scope: &config.Scope{ExcludedNamespaces: []string{"kube-*"}}},
+		{Name: "inject_env"},
+	}}
+	m, err := NewMutator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &request.Request{Operation: admissionv1.Create, Namespace: "kube-system"}
+	got, err := m.MutatePod(context.Background(), req, pod())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"inject_env"}, got.Applied)
+	assert.Empty(t, got.Pod.Spec.Tolerations)
+}
+
+func TestMutatePodScopeError(t *testing.T) {
+	// namespace labels are unknown, the namespace selector can't be matched
+	scope := &config.Scope{NamespaceSelector: &v1.LabelSelector{MatchLabels: map[string]string{"tier": "prod"}}}
+
+	cfg := &config.Config{Mutators: []config.Rule{
+		{Name: "min_lifespan", Mode: config.ModeWarn, Scope: scope},
+		{Name: "inject_env"},
+	}}
+	m, err := NewMutator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.MutatePod(context.Background(), createRequest(), pod())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"inject_env"}, got.Applied)
+
+	cfg.Mutators[0].Mode = config.ModeEnforce
+	m, err = NewMutator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.MutatePod(context.Background(), createRequest(), pod())
+	assert.Error(t, err)
+}
+
+func TestMutatePodExemptions(t *testing.T) {
+	cfg := config.Default()
+	cfg.Exemptions = &config.Exemptions{Groups: []string{"sre"}}
+	m, err := NewMutator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := pod()
+	p.Annotations = map[string]string{config.DefaultExemptionAnnotation: "min_lifespan"}
+
+	req := &request.Request{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "alice", Groups: []string{"sre"}},
+	}
+	got, err := m.MutatePod(context.Background(), req, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"inject_env"}, got.Applied)
+	assert.Empty(t, got.Warnings)
+
+	// the annotation is ignored for unauthorized users
+	req.UserInfo.Groups = nil
+	got, err = m.MutatePod(context.Background(), req, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"min_lifespan", "inject_env"}, got.Applied)
+	assert.Len(t, got.Warnings, 1)
+}
@@ -0,0 +1,175 @@
+package mutation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// seccompPodAnnotation is the deprecated annotation setting the seccomp
+// profile of a pod, the API server rejects pods whose annotation and field
+// disagree
+const seccompPodAnnotation = "seccomp.security.alpha.kubernetes.io/pod"
+
+// securityContext is a container for the mutation defaulting pod and
+// container security contexts
+type securityContext struct {
+	Logger logrus.FieldLogger
+	// Defaults sets which fields are defaulted
+	Defaults securityDefaults
+}
+
+// securityContext implements the podMutator interface
+var _ podMutator = (*securityContext)(nil)
+
+// securityDefaults sets which security context fields are defaulted, they
+// all are unless disabled
+type securityDefaults struct {
+	// RunAsNonRoot sets runAsNonRoot in the pod security context
+	RunAsNonRoot bool `json:"runAsNonRoot"`
+	// DisallowPrivilegeEscalation sets allowPrivilegeEscalation to false in
+	// the container security contexts
+	DisallowPrivilegeEscalation bool `json:"disallowPrivilegeEscalation"`
+	// DropAllCapabilities drops all capabilities in the container security
+	// contexts
+	DropAllCapabilities bool `json:"dropAllCapabilities"`
+	// SeccompRuntimeDefault sets the RuntimeDefault seccomp profile in the pod
+	// security context
+	SeccompRuntimeDefault bool `json:"seccompRuntimeDefault"`
+}
+
+// newSecurityContext returns a securityContext defaulting the fields enabled
+// in the rule params, all by default
+func newSecurityContext(logger logrus.FieldLogger, rule config.Rule) (podMutator, error) {
+	params := securityDefaults{
+		RunAsNonRoot:                true,
+		DisallowPrivilegeEscalation: true,
+		DropAllCapabilities:         true,
+		SeccompRuntimeDefault:       true,
+	}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	return securityContext{Logger: logger, Defaults: params}, nil
+}
+
+// Name returns the securityContext short name
+func (sc securityContext) Name() string {
+	return "security_context"
+}
+
+// Mutate returns a new mutated pod with secure defaults for the security
+// context fields it doesn't set, only new pods and pod templates are mutated.
+// Explicit values are never overridden, and defaults the API server or the
+// kubelet would reject along with explicit values are not set: runAsNonRoot
+// is not set if the pod or a container runs as user 0, privileged containers
+// and containers adding SYS_ADMIN keep privilege escalation, privileged
+// containers keep their capabilities, and no seccomp profile is set if the
+// pod has the deprecated seccomp annotation.
+func (sc securityContext) Mutate(ctx context.Context, req *request.Request, pod *corev1.Pod) (*corev1.Pod, error) {
+	sc.Logger = req.Log(sc.Logger).WithField("mutation", sc.Name())
+	mpod := pod.DeepCopy()
+
+	if !req.MutablePod() {
+		return mpod, nil
+	}
+
+	if sc.Defaults.RunAsNonRoot && !runsAsRoot(mpod) {
+		psc := podSecurityContext(mpod)
+		if psc.RunAsNonRoot == nil {
+			psc.RunAsNonRoot = boolPtr(true)
+		}
+	}
+
+	if _, ok := mpod.Annotations[seccompPodAnnotation]; sc.Defaults.SeccompRuntimeDefault && !ok {
+		psc := podSecurityContext(mpod)
+		if psc.SeccompProfile == nil {
+			psc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		}
+	}
+
+	for i := range mpod.Spec.InitContainers {
+		sc.defaultContainer(&mpod.Spec.InitContainers[i])
+	}
+	for i := range mpod.Spec.Containers {
+		sc.defaultContainer(&mpod.Spec.Containers[i])
+	}
+
+	return mpod, nil
+}
+
+// defaultContainer sets the defaults of the container security context
+func (sc securityContext) defaultContainer(c *corev1.Container) {
+	csc := c.SecurityContext
+	privileged := csc != nil && csc.Privileged != nil && *csc.Privileged
+
+	if sc.Defaults.DisallowPrivilegeEscalation && !privileged && !addsCapability(c, "SYS_ADMIN") &&
+		(csc == nil || csc.AllowPrivilegeEscalation == nil) {
+		containerSecurityContext(c).AllowPrivilegeEscalation = boolPtr(false)
+	}
+
+	if sc.Defaults.DropAllCapabilities && !privileged &&
+		(csc == nil || csc.Capabilities == nil || csc.Capabilities.Drop == nil) {
+		csc := containerSecurityContext(c)
+		if csc.Capabilities == nil {
+			csc.Capabilities = &corev1.Capabilities{}
+		}
+		csc.Capabilities.Drop = []corev1.Capability{"ALL"}
+	}
+}
+
+// runsAsRoot returns true if the pod or one of its containers explicitly runs
+// as user 0
+func runsAsRoot(pod *corev1.Pod) bool {
+	if psc := pod.Spec.SecurityContext; psc != nil && psc.RunAsUser != nil && *psc.RunAsUser == 0 {
+		return true
+	}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			if csc := c.SecurityContext; csc != nil && csc.RunAsUser != nil && *csc.RunAsUser == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addsCapability returns true if the container adds the given capability,
+// with or without the CAP_ prefix
+func addsCapability(c *corev1.Container, capability string) bool {
+	if c.SecurityContext == nil || c.SecurityContext.Capabilities == nil {
+		return false
+	}
+	for _, add := range c.SecurityContext.Capabilities.Add {
+		if strings.TrimPrefix(strings.ToUpper(string(add)), "CAP_") == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// podSecurityContext returns the pod security context, set empty if nil
+func podSecurityContext(pod *corev1.Pod) *corev1.PodSecurityContext {
+	if pod.Spec.SecurityContext == nil {
+		pod.Spec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	return pod.Spec.SecurityContext
+}
+
+// containerSecurityContext returns the container security context, set empty
+// if nil
+func containerSecurityContext(c *corev1.Container) *corev1.SecurityContext {
+	if c.SecurityContext == nil {
+		c.SecurityContext = &corev1.SecurityContext{}
+	}
+	return c.SecurityContext
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
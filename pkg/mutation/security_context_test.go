@@ -0,0 +1,112 @@
+package mutation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func TestSecurityContextMutate(t *testing.T) {
+	m, err := newSecurityContext(logger(), config.Rule{Name: "security_context"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init"}},
+		Containers: []corev1.Container{
+			{Name: "app"},
+			{Name: "explicit", SecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: boolPtr(true),
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"NET_RAW"}},
+			}},
+			{Name: "privileged", SecurityContext: &corev1.SecurityContext{Privileged: boolPtr(true)}},
+			{Name: "admin", SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"CAP_SYS_ADMIN"}},
+			}},
+		},
+	}}
+
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secure := &corev1.SecurityContext{
+		AllowPrivilegeEscalation: boolPtr(false),
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+	}
+	assert.Equal(t, &corev1.PodSecurityContext{
+		RunAsNonRoot:   boolPtr(true),
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}, got.Spec.SecurityContext)
+	assert.Equal(t, secure, got.Spec.InitContainers[0].SecurityContext)
+	assert.Equal(t, secure, got.Spec.Containers[0].SecurityContext)
+	assert.Equal(t, pod.Spec.Containers[1].SecurityContext, got.Spec.Containers[1].SecurityContext)
+	assert.Equal(t, pod.Spec.Containers[2].SecurityContext, got.Spec.Containers[2].SecurityContext)
+	assert.Equal(t, &corev1.SecurityContext{
+		Capabilities: &corev1.Capabilities{
+			Add:  []corev1.Capability{"CAP_SYS_ADMIN"},
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}, got.Spec.Containers[3].SecurityContext)
+}
+
+func TestSecurityContextExplicitPod(t *testing.T) {
+	m, err := newSecurityContext(logger(), config.Rule{Name: "security_context"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{seccompPodAnnotation: "unconfined"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:            "root",
+			SecurityContext: &corev1.SecurityContext{RunAsUser: int64Ptr(0)},
+		}}},
+	}
+
+	got, err := m.Mutate(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, got.Spec.SecurityContext)
+}
+
+func TestSecurityContextPatch(t *testing.T) {
+	rule := config.Rule{
+		Name:   "security_context",
+		Params: json.RawMessage(`{"runAsNonRoot":false,"seccompRuntimeDefault":false,"dropAllCapabilities":false}`),
+	}
+	m, err := NewMutator(logger(), &config.Config{Mutators: []config.Rule{rule}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app", SecurityContext: &corev1.SecurityContext{RunAsUser: int64Ptr(1000)}},
+		{Name: "set", SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: boolPtr(false)}},
+	}}}
+
+	got, err := m.MutatePodPatch(context.Background(), createRequest(), pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `[
+		{"op":"add","path":"/spec/containers/0/securityContext/allowPrivilegeEscalation","value":false}
+	]`, string(got))
+}
+
+func TestNewSecurityContextInvalid(t *testing.T) {
+	_, err := newSecurityContext(logger(), config.Rule{Name: "security_context", Params: json.RawMessage(`{"runAsUser":1000}`)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+// Package namespace keeps an in-memory cache of namespaces, so that rules can
+// be scoped by namespace labels without querying the API server on every
+// admission request
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resync is how often the cache is fully resynced from the API server
+const resync = 10 * time.Minute
+
+// Cache looks up namespace labels from a namespace informer
+type Cache struct {
+	lister listersv1.NamespaceLister
+	stop   context.CancelFunc
+}
+
+// NewCache starts watching namespaces with the given client, it blocks until
+// the cache is synced and returns an error if it isn't within timeout, ie.
+// when the webhook is not allowed to list and watch namespaces. The informer
+// stops when ctx is done or the cache is stopped, it is stopped right away if
+// an error is returned.
+func NewCache(ctx context.Context, client kubernetes.Interface, timeout time.Duration) (*Cache, error) {
+	ctx, stop := context.WithCancel(ctx)
+	factory := informers.NewSharedInformerFactory(client, resync)
+	namespaces := factory.Core().V1().Namespaces()
+	lister := namespaces.Lister()
+	synced := namespaces.Informer().HasSynced
+
+	factory.Start(ctx.Done())
+	syncCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced) {
+		stop()
+		return nil, fmt.Errorf("namespace cache not synced within %s", timeout)
+	}
+
+	return &Cache{lister: lister, stop: stop}, nil
+}
+
+// Stop stops watching namespaces, labels are no longer updated
+func (c *Cache) Stop() {
+	c.stop()
+}
+
+// Labels returns the labels of the given namespace, never nil for an
+// existing namespace
+func (c *Cache) Labels(name string) (map[string]string, error) {
+	ns, err := c.lister.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if ns.Labels == nil {
+		return map[string]string{}, nil
+	}
+	return ns.Labels, nil
+}
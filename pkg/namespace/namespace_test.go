@@ -0,0 +1,59 @@
+package namespace
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCacheLabels(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "apps",
+			Labels: map[string]string{"tier": "prod"},
+		}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bare"}},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := NewCache(ctx, client, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	got, err := c.Labels("apps")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"tier": "prod"}, got)
+
+	got, err = c.Labels("bare")
+	assert.Nil(t, err)
+	assert.NotNil(t, got)
+
+	_, err = c.Labels("missing")
+	assert.Error(t, err)
+}
+
+func TestCacheNotSynced(t *testing.T) {
+	// namespaces can't be listed, as without RBAC
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := NewCache(ctx, client, 50*time.Millisecond)
+	assert.EqualError(t, err, "namespace cache not synced within 50ms")
+}
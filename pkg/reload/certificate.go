@@ -0,0 +1,54 @@
+package reload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Certificate holds a TLS certificate pair loaded from disk
+type Certificate struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Value // *tls.Certificate
+}
+
+// NewCertificate returns a Certificate loaded from the given cert and key
+// files
+func NewCertificate(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload reads the certificate pair from disk again, the current certificate
+// is kept if the new pair can't be loaded
+func (c *Certificate) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %v", err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("could not parse TLS certificate: %v", err)
+	}
+
+	c.cert.Store(&cert)
+	return nil
+}
+
+// GetCertificate returns the current certificate, it is meant to be used as
+// tls.Config.GetCertificate
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load().(*tls.Certificate), nil
+}
+
+// NotAfter returns the expiry of the current certificate
+func (c *Certificate) NotAfter() time.Time {
+	return c.cert.Load().(*tls.Certificate).Leaf.NotAfter
+}
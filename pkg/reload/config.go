@@ -0,0 +1,62 @@
+package reload
+
+import (
+	"sync/atomic"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/admission"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+)
+
+// Config holds the webhook policy loaded from a config file, along with the
+// mutator and validator chains it enables
+type Config struct {
+	path   string
+	build  func(*config.Config) (*admission.Policy, error)
+	policy atomic.Value // *admission.Policy
+}
+
+// NewConfig returns a Config loaded from path, the mutator and validator
+// chains of each loaded config are built with build, configs it fails on are
+// not used. The default policy is used when path is empty.
+func NewConfig(path string, build func(*config.Config) (*admission.Policy, error)) (*Config, error) {
+	c := &Config{path: path, build: build}
+	if path == "" {
+		p, err := build(config.Default())
+		if err != nil {
+			return nil, err
+		}
+		c.policy.Store(p)
+		return c, nil
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload reads the config file again and rebuilds its chains, the current
+// policy is kept if the new config can't be loaded or built
+func (c *Config) Reload() error {
+	if c.path == "" {
+		return nil
+	}
+
+	cfg, err := config.Load(c.path)
+	if err != nil {
+		return err
+	}
+	p, err := c.build(cfg)
+	if err != nil {
+		return err
+	}
+
+	c.policy.Store(p)
+	return nil
+}
+
+// Get returns the current policy
+func (c *Config) Get() *admission.Policy {
+	return c.policy.Load().(*admission.Policy)
+}
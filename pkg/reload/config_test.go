@@ -0,0 +1,60 @@
+package reload
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/admission"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(tempDir(t), "config.yaml")
+	writeFile(t, path, "validators: [{name: name_validator}]")
+
+	builds := 0
+	build := func(cfg *config.Config) (*admission.Policy, error) {
+		builds++
+		return admission.NewPolicy(cfg)
+	}
+
+	c, err := NewConfig(path, build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := c.Get()
+	assert.Equal(t, []config.Rule{{Name: "name_validator"}}, p.Config.Validators)
+	assert.NotNil(t, p.Validator)
+
+	// chains are only built on load
+	assert.Same(t, p, c.Get())
+	assert.Equal(t, 1, builds)
+
+	writeFile(t, path, "validators: []")
+	assert.Nil(t, c.Reload())
+	assert.Empty(t, c.Get().Config.Validators)
+	assert.Equal(t, 2, builds)
+
+	// configs failing to build are not swapped in
+	writeFile(t, path, "validators: [{name: nope}]")
+	assert.Error(t, c.Reload())
+	assert.Empty(t, c.Get().Config.Validators)
+}
+
+func TestConfigDefault(t *testing.T) {
+	c, err := NewConfig("", admission.NewPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, config.Default(), c.Get().Config)
+	assert.Nil(t, c.Reload())
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,59 @@
+// Package reload keeps files loaded from disk up to date while the webhook is
+// running, such as the policy config and the TLS certificate, new versions are
+// swapped in atomically so in flight requests are not affected
+package reload
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// Watch calls onChange whenever any of the given files change, until ctx is
+// done. Parent directories are watched rather than the files themselves so
+// that atomic symlink swaps, as done by kubelet for mounted secrets and
+// config maps, are picked up.
+func Watch(ctx context.Context, logger logrus.FieldLogger, paths []string, onChange func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := map[string]bool{}
+	for _, p := range paths {
+		dir := filepath.Dir(p)
+		if dirs[dir] {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return err
+		}
+		dirs[dir] = true
+	}
+
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				logger.WithField("file", e.Name).Debugf("file event %s", e.Op)
+				onChange()
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logger.Errorf("file watcher error: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
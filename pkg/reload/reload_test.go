@@ -0,0 +1,58 @@
+package reload
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, "first")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int32
+	err := Watch(ctx, logger(), []string{path}, func() {
+		atomic.AddInt32(&calls, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, path, "second")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWatchMissingDir(t *testing.T) {
+	path := filepath.Join(tempDir(t), "missing", "config.yaml")
+	err := Watch(context.Background(), logger(), []string{path}, func() {})
+	assert.Error(t, err)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func logger() *logrus.Entry {
+	mute := logrus.StandardLogger()
+	mute.Out = ioutil.Discard
+	return mute.WithField("logger", "test")
+}
@@ -0,0 +1,77 @@
+// Package request describes the admission request an object is mutated or
+// validated under, so that rules can act on the operation, the requesting
+// user or the object being replaced
+package request
+
+import (
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Request holds the admission request attributes passed down to mutators and
+// validators
+type Request struct {
+	// Operation is the operation being performed, CONNECT requests don't carry
+	// an object and are not passed down to rules
+	Operation admissionv1.Operation
+	Namespace string
+	// NamespaceLabels are the labels of the namespace, nil if they could not
+	// be looked up
+	NamespaceLabels map[string]string
+	UserInfo        authenticationv1.UserInfo
+	// DryRun is true when the request won't be persisted, rules should skip
+	// any side effect
+	DryRun bool
+	// OldObject is the object being replaced on UPDATE or removed on DELETE,
+	// it is nil otherwise
+	OldObject runtime.Object
+	// Template is true when the admitted pod is the pod template of a workload
+	// rather than an actual pod
+	Template bool
+	// Logger logs with the fields of the admission request, rules built once
+	// per config log through Log rather than their own logger
+	Logger logrus.FieldLogger
+}
+
+// WithLogger returns a copy of the request logging to the given logger
+func (r *Request) WithLogger(logger logrus.FieldLogger) *Request {
+	c := *r
+	c.Logger = logger
+	return &c
+}
+
+// Log returns the logger of the admission request, or fallback if it is unset
+func (r *Request) Log(fallback logrus.FieldLogger) logrus.FieldLogger {
+	if r == nil || r.Logger == nil {
+		return fallback
+	}
+	return r.Logger
+}
+
+// OldPod returns the pod being replaced or removed, or nil
+func (r *Request) OldPod() *corev1.Pod {
+	pod, _ := r.OldObject.(*corev1.Pod)
+	return pod
+}
+
+// OldService returns the service being replaced or removed, or nil
+func (r *Request) OldService() *corev1.Service {
+	svc, _ := r.OldObject.(*corev1.Service)
+	return svc
+}
+
+// MutablePod returns true if the admitted pod can be mutated, that is on
+// CREATE for pods or on CREATE and UPDATE for pod templates. Pod specs are
+// immutable once created.
+func (r *Request) MutablePod() bool {
+	switch r.Operation {
+	case admissionv1.Create:
+		return true
+	case admissionv1.Update:
+		return r.Template
+	}
+	return false
+}
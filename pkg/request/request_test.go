@@ -0,0 +1,63 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOldObject(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod"}}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc"}}
+
+	r := &Request{OldObject: pod}
+	assert.Equal(t, pod, r.OldPod())
+	assert.Nil(t, r.OldService())
+
+	r = &Request{OldObject: svc}
+	assert.Nil(t, r.OldPod())
+	assert.Equal(t, svc, r.OldService())
+
+	r = &Request{}
+	assert.Nil(t, r.OldPod())
+	assert.Nil(t, r.OldService())
+}
+
+func TestLog(t *testing.T) {
+	fallback := logrus.WithField("logger", "fallback")
+	logger := logrus.WithField("logger", "request")
+
+	r := &Request{Operation: admissionv1.Create}
+	assert.Equal(t, fallback, r.Log(fallback))
+
+	c := r.WithLogger(logger)
+	assert.Equal(t, logger, c.Log(fallback))
+	assert.Nil(t, r.Logger)
+	assert.Equal(t, admissionv1.Create, c.Operation)
+
+	r = nil
+	assert.Equal(t, fallback, r.Log(fallback))
+}
+
+func TestMutablePod(t *testing.T) {
+	for _, tc := range []struct {
+		op       admissionv1.Operation
+		template bool
+		want     bool
+	}{
+		{admissionv1.Create, false, true},
+		{admissionv1.Create, true, true},
+		{admissionv1.Update, false, false},
+		{admissionv1.Update, true, true},
+		{admissionv1.Delete, false, false},
+		{admissionv1.Delete, true, false},
+		{admissionv1.Connect, false, false},
+	} {
+		r := &Request{Operation: tc.op, Template: tc.template}
+		assert.Equal(t, tc.want, r.MutablePod(), "%s template=%t", tc.op, tc.template)
+	}
+}
@@ -0,0 +1,129 @@
+// Package scope matches admitted objects against the scope of a rule, so that
+// a single webhook can apply different policies to different namespaces or
+// workloads
+package scope
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Scope is a parsed config.Scope, the zero value and nil match all objects
+type Scope struct {
+	namespaces         []string
+	excludedNamespaces []string
+	namespaceSelector  labels.Selector
+	objectSelector     labels.Selector
+	names              []string
+}
+
+// New parses the given config scope, it returns an error on malformed
+// patterns or selectors. A nil config scope matches all objects.
+func New(s *config.Scope) (*Scope, error) {
+	if s == nil {
+		return &Scope{}, nil
+	}
+
+	for _, patterns := range [][]string{s.Namespaces, s.ExcludedNamespaces, s.Names} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+			}
+		}
+	}
+
+	sc := &Scope{
+		namespaces:         s.Namespaces,
+		excludedNamespaces: s.ExcludedNamespaces,
+		names:              s.Names,
+	}
+
+	var err error
+	if s.NamespaceSelector != nil {
+		sc.namespaceSelector, err = metav1.LabelSelectorAsSelector(s.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %v", err)
+		}
+	}
+	if s.ObjectSelector != nil {
+		sc.objectSelector, err = metav1.LabelSelectorAsSelector(s.ObjectSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object selector: %v", err)
+		}
+	}
+
+	return sc, nil
+}
+
+// Matches returns true if the given object, admitted under req, is in scope.
+// On UPDATE the object selector matches if either the new or the old object
+// matches, the same way the API server handles webhook object selectors. An
+// error is returned if the scope has a namespace selector but the namespace
+// labels could not be looked up.
+func (s *Scope) Matches(req *request.Request, obj metav1.Object) (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+
+	namespace := req.Namespace
+	if namespace == "" {
+		namespace = obj.GetNamespace()
+	}
+
+	if len(s.namespaces) > 0 && !matchAny(s.namespaces, namespace) {
+		return false, nil
+	}
+	if matchAny(s.excludedNamespaces, namespace) {
+		return false, nil
+	}
+
+	if len(s.names) > 0 {
+		name := obj.GetName()
+		if name == "" {
+			name = obj.GetGenerateName()
+		}
+		if !matchAny(s.names, name) {
+			return false, nil
+		}
+	}
+
+	if s.objectSelector != nil && !s.objectSelector.Matches(labels.Set(obj.GetLabels())) {
+		old, ok := req.OldObject.(metav1.Object)
+		if !ok || !s.objectSelector.Matches(labels.Set(old.GetLabels())) {
+			return false, nil
+		}
+	}
+
+	if s.namespaceSelector != nil {
+		if req.NamespaceLabels == nil {
+			return false, fmt.Errorf("labels of namespace %q are not available for namespace selector", namespace)
+		}
+		if !s.namespaceSelector.Matches(labels.Set(req.NamespaceLabels)) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// NamespaceSelector returns true if the scope has a namespace selector,
+// namespace labels then have to be looked up to match objects
+func (s *Scope) NamespaceSelector() bool {
+	return s != nil && s.namespaceSelector != nil
+}
+
+// matchAny returns true if name matches one of the given glob patterns,
+// patterns are checked in New
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
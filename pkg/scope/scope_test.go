@@ -0,0 +1,118 @@
+package scope
+
+import (
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(&config.Scope{Names: []string{"["}})
+	assert.Error(t, err)
+
+	_, err = New(&config.Scope{ObjectSelector: &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Nope"}},
+	}})
+	assert.Error(t, err)
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	var nilScope *Scope
+	assert.False(t, nilScope.NamespaceSelector())
+
+	sc, err := New(&config.Scope{Namespaces: []string{"team-*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, sc.NamespaceSelector())
+
+	sc, err = New(&config.Scope{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "prod"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, sc.NamespaceSelector())
+}
+
+func TestScopeMatches(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "web-",
+			Labels:       map[string]string{"app": "web"},
+		},
+	}
+	create := &request.Request{
+		Operation:       admissionv1.Create,
+		Namespace:       "team-a",
+		NamespaceLabels: map[string]string{"tier": "prod"},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		scope *config.Scope
+		want  bool
+	}{
+		{"nil", nil, true},
+		{"namespace", &config.Scope{Namespaces: []string{"team-*"}}, true},
+		{"other namespace", &config.Scope{Namespaces: []string{"kube-system"}}, false},
+		{"excluded namespace", &config.Scope{ExcludedNamespaces: []string{"team-a"}}, false},
+		{"generate name", &config.Scope{Names: []string{"web-*"}}, true},
+		{"other name", &config.Scope{Names: []string{"db-*"}}, false},
+		{"object selector", &config.Scope{ObjectSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"app": "web"},
+		}}, true},
+		{"other object selector", &config.Scope{ObjectSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"app": "db"},
+		}}, false},
+		{"namespace selector", &config.Scope{NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"tier": "prod"},
+		}}, true},
+		{"other namespace selector", &config.Scope{NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"tier": "dev"},
+		}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := New(tc.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.Matches(create, pod)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestScopeMatchesOldObject(t *testing.T) {
+	s, err := New(&config.Scope{ObjectSelector: &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "web"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// removing the label on update still matches the old object
+	old := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}}}
+	req := &request.Request{Operation: admissionv1.Update, OldObject: old}
+
+	got, err := s.Matches(req, &corev1.Pod{})
+	assert.Nil(t, err)
+	assert.True(t, got)
+}
+
+func TestScopeMatchesMissingNamespaceLabels(t *testing.T) {
+	s, err := New(&config.Scope{NamespaceSelector: &metav1.LabelSelector{
+		MatchLabels: map[string]string{"tier": "prod"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Matches(&request.Request{Namespace: "team-a"}, &corev1.Pod{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/metrics"
+)
+
+// ClientAuth authenticates callers by their client certificate, which must
+// be signed by a CA of the client CA bundle and, if allowed names are set,
+// be issued to one of them
+type ClientAuth struct {
+	logger       logrus.FieldLogger
+	caFile       string
+	allowedNames map[string]bool
+	roots        atomic.Value // *x509.CertPool
+}
+
+// NewClientAuth returns a ClientAuth verifying client certificates against
+// the CA bundle in caFile. Certificates are matched against allowedNames by
+// subject common name and by DNS, email and URI SANs, any name is allowed if
+// empty.
+func NewClientAuth(logger logrus.FieldLogger, caFile string, allowedNames []string) (*ClientAuth, error) {
+	a := &ClientAuth{logger: logger, caFile: caFile}
+	if len(allowedNames) > 0 {
+		a.allowedNames = map[string]bool{}
+		for _, n := range allowedNames {
+			a.allowedNames[n] = true
+		}
+	}
+
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Reload reads the CA bundle from disk again, the current bundle is kept if
+// the new one can't be loaded
+func (a *ClientAuth) Reload() error {
+	pem, err := ioutil.ReadFile(a.caFile)
+	if err != nil {
+		return fmt.Errorf("could not read client CA file: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificate found in client CA file %q", a.caFile)
+	}
+
+	a.roots.Store(roots)
+	return nil
+}
+
+// Require returns a handler rejecting unauthenticated callers with
+// 401 Unauthorized and allowed but not authorized ones with 403 Forbidden,
+// before handing requests over to next. A nil ClientAuth doesn't check
+// callers.
+func (a *ClientAuth) Require(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, reason := a.authenticate(r.TLS)
+		if reason == "" {
+			a.logger.WithField("uri", r.RequestURI).Tracef("authenticated client %q", name)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metrics.ClientRejections.WithLabelValues(reason).Inc()
+		a.logger.WithFields(logrus.Fields{
+			"uri":    r.RequestURI,
+			"remote": r.RemoteAddr,
+			"client": name,
+		}).Warnf("rejected client: %s", reason)
+
+		if reason == metrics.RejectionNotAllowed {
+			http.Error(w, "client not allowed", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "valid client certificate required", http.StatusUnauthorized)
+	})
+}
+
+// authenticate verifies the client certificate of the connection and returns
+// its common name, along with the reason it is rejected if it is
+func (a *ClientAuth) authenticate(state *tls.ConnectionState) (string, string) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return "", metrics.RejectionNoCertificate
+	}
+
+	cert := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         a.roots.Load().(*x509.CertPool),
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return cert.Subject.CommonName, metrics.RejectionUntrusted
+	}
+
+	if !a.allowed(cert) {
+		return cert.Subject.CommonName, metrics.RejectionNotAllowed
+	}
+
+	return cert.Subject.CommonName, ""
+}
+
+// allowed returns true if the certificate is issued to an allowed name
+func (a *ClientAuth) allowed(cert *x509.Certificate) bool {
+	if a.allowedNames == nil {
+		return true
+	}
+
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+
+	for _, n := range names {
+		if a.allowedNames[n] {
+			return true
+		}
+	}
+	return false
+}
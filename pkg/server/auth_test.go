@@ -0,0 +1,130 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func logger() logrus.FieldLogger {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	return l
+}
+
+func TestClientAuth(t *testing.T) {
+	ca := newAuthority(t)
+	auth, err := NewClientAuth(logger(), ca.writePEM(t), []string{"kube-apiserver", "apiserver.cluster.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{TLS: true, MinTLSVersion: "1.2", ClientCAFile: "ca.crt"}
+	srv := serve(t, c, ca, auth.Require(ok))
+
+	tests := []struct {
+		name   string
+		issuer *authority
+		cn     string
+		dns    []string
+		want   int
+		reason string
+	}{
+		{name: "allowed common name", issuer: ca, cn: "kube-apiserver", want: http.StatusOK},
+		{name: "allowed SAN", issuer: ca, cn: "apiserver", dns: []string{"apiserver.cluster.local"}, want: http.StatusOK},
+		{name: "no certificate", want: http.StatusUnauthorized, reason: metrics.RejectionNoCertificate},
+		{name: "untrusted", issuer: newAuthority(t), cn: "kube-apiserver", want: http.StatusUnauthorized, reason: metrics.RejectionUntrusted},
+		{name: "not allowed", issuer: ca, cn: "curious-pod", dns: []string{"curious-pod.default.svc"}, want: http.StatusForbidden, reason: metrics.RejectionNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := 0.0
+			if tt.reason != "" {
+				before = testutil.ToFloat64(metrics.ClientRejections.WithLabelValues(tt.reason))
+			}
+
+			var code int
+			var err error
+			if tt.issuer == nil {
+				code, err = get(t, srv, ca)
+			} else {
+				code, err = get(t, srv, ca, tt.issuer.issue(t, tt.cn, tt.dns...))
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, code)
+
+			if tt.reason != "" {
+				assert.Equal(t, before+1, testutil.ToFloat64(metrics.ClientRejections.WithLabelValues(tt.reason)))
+			}
+		})
+	}
+}
+
+func TestClientAuthAnyName(t *testing.T) {
+	ca := newAuthority(t)
+	auth, err := NewClientAuth(logger(), ca.writePEM(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{TLS: true, MinTLSVersion: "1.2", ClientCAFile: "ca.crt"}
+	srv := serve(t, c, ca, auth.Require(ok))
+
+	code, err := get(t, srv, ca, ca.issue(t, "anyone"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestClientAuthReload(t *testing.T) {
+	first, second := newAuthority(t), newAuthority(t)
+	caFile := first.writePEM(t)
+	auth, err := NewClientAuth(logger(), caFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{TLS: true, MinTLSVersion: "1.2", ClientCAFile: caFile}
+	srv := serve(t, c, first, auth.Require(ok))
+
+	code, _ := get(t, srv, first, second.issue(t, "kube-apiserver"))
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	if err := os.Rename(second.writePEM(t), caFile); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, auth.Reload())
+	code, _ = get(t, srv, first, second.issue(t, "kube-apiserver"))
+	assert.Equal(t, http.StatusOK, code)
+
+	// a broken bundle keeps the current one
+	if err := ioutil.WriteFile(caFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, auth.Reload())
+	code, _ = get(t, srv, first, second.issue(t, "kube-apiserver"))
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestNewClientAuthInvalid(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	_, err := NewClientAuth(logger(), caFile, nil)
+	assert.Error(t, err)
+}
+
+func TestClientAuthNil(t *testing.T) {
+	ca := newAuthority(t)
+	c := &Config{TLS: true, MinTLSVersion: "1.2"}
+	var auth *ClientAuth
+	srv := serve(t, c, ca, auth.Require(ok))
+
+	code, err := get(t, srv, ca)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+}
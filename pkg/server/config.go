@@ -0,0 +1,300 @@
+// Package server configures the HTTP server the webhook listens on
+package server
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the listener configuration of the webhook
+type Config struct {
+	// ConfigFile is the policy config file, watched and reloaded on change,
+	// the default policy is used if empty
+	ConfigFile string
+	// Addr is the address to listen on, ":443" with TLS and ":8080"
+	// without by default
+	Addr string
+	// TLS serves over TLS
+	TLS bool
+	// CertFile and KeyFile are the serving certificate pair, they are
+	// watched and reloaded on change
+	CertFile string
+	KeyFile  string
+	// MinTLSVersion is the minimum TLS version accepted, "1.2" by default
+	MinTLSVersion string
+	// CipherSuites are the names of the cipher suites accepted for TLS 1.2,
+	// the Go defaults if empty
+	CipherSuites []string
+	// ClientCAFile is a PEM bundle of the CAs client certificates are
+	// verified against, client certificates are not required if empty
+	ClientCAFile string
+	// ClientAllowedNames are the names client certificates must be issued to,
+	// any name signed by the client CA is allowed if empty
+	ClientAllowedNames []string
+
+	// CertBootstrap generates the serving certificate on startup, see the
+	// certs package, it implies TLS
+	CertBootstrap bool
+	// Namespace is the namespace of the webhook
+	Namespace string
+	// ServiceName is the service the serving certificate is issued for
+	ServiceName string
+	// CertSecret is the Secret bootstrapped certificates are persisted to
+	CertSecret string
+	// WebhookConfigs are the names of the webhook configurations whose
+	// caBundle is patched with the bootstrapped CA
+	WebhookConfigs []string
+
+	// ShutdownDelay is how long requests are still served once shutdown
+	// starts, while the webhook is reported unready so that it is removed
+	// from the service endpoints
+	ShutdownDelay time.Duration
+	// ShutdownGracePeriod is how long in-flight requests are given to
+	// complete after the shutdown delay
+	ShutdownGracePeriod time.Duration
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout are the
+	// timeouts of the HTTP server
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// MaxRequestBytes is the maximum size of admission request bodies
+	MaxRequestBytes int64
+	// AdmissionTimeout is how long mutators and validators are given to
+	// handle an admission request, it must be shorter than the timeoutSeconds
+	// of the webhook configurations so that slow rules fail deterministically
+	AdmissionTimeout time.Duration
+}
+
+// DefaultMaxRequestBytes is the default maximum size of admission request
+// bodies, twice what an object and its old version take at the 1.5MiB etcd
+// object size limit
+const DefaultMaxRequestBytes = 6 << 20
+
+// defaultCertDir is the directory the serving certificate pair is mounted in
+const defaultCertDir = "/etc/admission-webhook/tls"
+
+// Parse returns the Config set by the given command line flags, falling back
+// to env vars read with getenv for unset flags, and to defaults for unset env
+// vars
+func Parse(args []string, getenv func(string) string) (*Config, error) {
+	c := &Config{}
+	fs := flag.NewFlagSet("simple-kubernetes-webhook", flag.ContinueOnError)
+
+	stringVar := func(p *string, name, key, def, usage string) {
+		if v := getenv(key); v != "" {
+			def = v
+		}
+		fs.StringVar(p, name, def, usage+" (env "+key+")")
+	}
+	// invalid non string env vars are only reported if not overridden by
+	// flags
+	envErrs := map[string]error{}
+	boolVar := func(p *bool, name, key, usage string) {
+		def := false
+		if v := getenv(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				envErrs[name] = fmt.Errorf("invalid %s %q: not a boolean", key, v)
+			}
+			def = b
+		}
+		fs.BoolVar(p, name, def, usage+" (env "+key+")")
+	}
+	int64Var := func(p *int64, name, key string, def int64, usage string) {
+		if v := getenv(key); v != "" {
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				envErrs[name] = fmt.Errorf("invalid %s %q: not an integer", key, v)
+			}
+			def = i
+		}
+		fs.Int64Var(p, name, def, usage+" (env "+key+")")
+	}
+	durationVar := func(p *time.Duration, name, key string, def time.Duration, usage string) {
+		if v := getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				envErrs[name] = fmt.Errorf("invalid %s %q: not a duration", key, v)
+			}
+			def = d
+		}
+		fs.DurationVar(p, name, def, usage+" (env "+key+")")
+	}
+
+	var cipherSuites, clientAllowedNames, webhookConfigs string
+	stringVar(&c.ConfigFile, "config-file", "CONFIG_FILE", "", "policy config file, the default policy is used if empty")
+	stringVar(&c.Addr, "listen-addr", "LISTEN_ADDR", "", `address to listen on, ":443" with TLS and ":8080" without by default`)
+	boolVar(&c.TLS, "tls", "TLS", "serve over TLS")
+	stringVar(&c.CertFile, "tls-cert-file", "TLS_CERT_FILE", "", "serving certificate file (default \""+defaultCertDir+"/tls.crt\")")
+	stringVar(&c.KeyFile, "tls-key-file", "TLS_KEY_FILE", "", "serving key file (default \""+defaultCertDir+"/tls.key\")")
+	stringVar(&c.MinTLSVersion, "tls-min-version", "TLS_MIN_VERSION", "1.2", "minimum TLS version, one of 1.0, 1.1, 1.2 or 1.3")
+	stringVar(&cipherSuites, "tls-cipher-suites", "TLS_CIPHER_SUITES", "", "comma separated cipher suites accepted for TLS 1.2, the Go defaults if empty")
+	stringVar(&c.ClientCAFile, "client-ca-file", "CLIENT_CA_FILE", "", "CA bundle client certificates are verified against, client certificates are not required if empty")
+	stringVar(&clientAllowedNames, "client-allowed-names", "CLIENT_ALLOWED_NAMES", "", "comma separated names client certificates must be issued to, by common name or SAN, any name is allowed if empty")
+	boolVar(&c.CertBootstrap, "cert-bootstrap", "CERT_BOOTSTRAP", "generate the serving certificate on startup, implies TLS")
+	stringVar(&c.Namespace, "namespace", "POD_NAMESPACE", "", "namespace of the webhook, required to bootstrap certificates")
+	stringVar(&c.ServiceName, "service-name", "SERVICE_NAME", "simple-kubernetes-webhook", "service the bootstrapped certificate is issued for")
+	stringVar(&c.CertSecret, "cert-secret", "CERT_SECRET", "simple-kubernetes-webhook-certs", "secret bootstrapped certificates are persisted to")
+	stringVar(&webhookConfigs, "webhook-configs", "WEBHOOK_CONFIGS", "simple-kubernetes-webhook.acme.com", "comma separated webhook configurations the bootstrapped CA is patched into")
+	durationVar(&c.ShutdownDelay, "shutdown-delay", "SHUTDOWN_DELAY", 5*time.Second, "time requests are still served after SIGTERM while the webhook is reported unready")
+	durationVar(&c.ShutdownGracePeriod, "shutdown-grace-period", "SHUTDOWN_GRACE_PERIOD", 20*time.Second, "time in-flight requests are given to complete on shutdown")
+	durationVar(&c.ReadHeaderTimeout, "read-header-timeout", "READ_HEADER_TIMEOUT", 5*time.Second, "time allowed to read request headers")
+	durationVar(&c.ReadTimeout, "read-timeout", "READ_TIMEOUT", 10*time.Second, "time allowed to read requests, body included")
+	durationVar(&c.WriteTimeout, "write-timeout", "WRITE_TIMEOUT", 15*time.Second, "time allowed to handle requests and write responses, must exceed the admission timeout")
+	durationVar(&c.IdleTimeout, "idle-timeout", "IDLE_TIMEOUT", 2*time.Minute, "time keep-alive connections are kept open between requests")
+	int64Var(&c.MaxRequestBytes, "max-request-bytes", "MAX_REQUEST_BYTES", DefaultMaxRequestBytes, "maximum size of admission request bodies")
+	durationVar(&c.AdmissionTimeout, "admission-timeout", "ADMISSION_TIMEOUT", 1500*time.Millisecond, "time mutators and validators are given per admission request, keep it under the webhook timeoutSeconds")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) { delete(envErrs, f.Name) })
+	for _, err := range envErrs {
+		return nil, err
+	}
+
+	c.CipherSuites = split(cipherSuites)
+	c.ClientAllowedNames = split(clientAllowedNames)
+	c.WebhookConfigs = split(webhookConfigs)
+	c.setDefaults()
+
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// setDefaults sets the defaults depending on other settings
+func (c *Config) setDefaults() {
+	if c.CertBootstrap {
+		c.TLS = true
+	}
+
+	if c.Addr == "" {
+		c.Addr = ":8080"
+		if c.TLS {
+			c.Addr = ":443"
+		}
+	}
+
+	// bootstrapped certificates are written to a writable directory rather
+	// than where a Secret would be mounted
+	certDir := defaultCertDir
+	if c.CertBootstrap {
+		certDir = filepath.Join(os.TempDir(), "simple-kubernetes-webhook")
+	}
+	if c.CertFile == "" {
+		c.CertFile = filepath.Join(certDir, "tls.crt")
+	}
+	if c.KeyFile == "" {
+		c.KeyFile = filepath.Join(certDir, "tls.key")
+	}
+}
+
+// check returns an error if the config is invalid
+func (c *Config) check() error {
+	if !c.TLS && c.ClientCAFile != "" {
+		return fmt.Errorf("client CA file requires TLS")
+	}
+	if c.ClientCAFile == "" && len(c.ClientAllowedNames) > 0 {
+		return fmt.Errorf("client allowed names require a client CA file")
+	}
+	if c.CertBootstrap && c.Namespace == "" {
+		return fmt.Errorf("namespace is required to bootstrap certificates")
+	}
+	if c.CertBootstrap && c.ServiceName == "" {
+		return fmt.Errorf("service name is required to bootstrap certificates")
+	}
+
+	for _, d := range []time.Duration{c.ShutdownDelay, c.ShutdownGracePeriod,
+		c.ReadHeaderTimeout, c.ReadTimeout, c.WriteTimeout, c.IdleTimeout} {
+		if d < 0 {
+			return fmt.Errorf("durations must not be negative")
+		}
+	}
+	if c.AdmissionTimeout <= 0 {
+		return fmt.Errorf("admission timeout must be positive")
+	}
+	if c.WriteTimeout > 0 && c.WriteTimeout <= c.AdmissionTimeout {
+		return fmt.Errorf("write timeout %s must exceed the admission timeout %s", c.WriteTimeout, c.AdmissionTimeout)
+	}
+	if c.MaxRequestBytes <= 0 {
+		return fmt.Errorf("max request bytes must be positive")
+	}
+
+	if _, err := tlsVersion(c.MinTLSVersion); err != nil {
+		return err
+	}
+	if _, err := cipherSuites(c.CipherSuites); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DNSNames returns the names the bootstrapped serving certificate is issued
+// for
+func (c *Config) DNSNames() []string {
+	svc := c.ServiceName
+	return []string{svc, svc + "." + c.Namespace, svc + "." + c.Namespace + ".svc"}
+}
+
+// tlsVersions are the TLS versions by name
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsVersion returns the TLS version of the given name
+func tlsVersion(name string) (uint16, error) {
+	v, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q", name)
+	}
+	return v, nil
+}
+
+// cipherSuites returns the IDs of the named cipher suites, only suites
+// without known security issues are supported
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		ids[s.Name] = s.ID
+	}
+
+	suites := make([]uint16, len(names))
+	for i, name := range names {
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		suites[i] = id
+	}
+	return suites, nil
+}
+
+// split returns the non empty comma separated values of s
+func split(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
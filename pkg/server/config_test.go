@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getenv(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestParseDefaults(t *testing.T) {
+	c, err := Parse(nil, getenv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "", c.ConfigFile)
+	assert.Equal(t, ":8080", c.Addr)
+	assert.False(t, c.TLS)
+	assert.Equal(t, "/etc/admission-webhook/tls/tls.crt", c.CertFile)
+	assert.Equal(t, "/etc/admission-webhook/tls/tls.key", c.KeyFile)
+	assert.Equal(t, "1.2", c.MinTLSVersion)
+	assert.Nil(t, c.CipherSuites)
+	assert.Equal(t, []string{"simple-kubernetes-webhook.acme.com"}, c.WebhookConfigs)
+	assert.Equal(t, 5*time.Second, c.ShutdownDelay)
+	assert.Equal(t, 20*time.Second, c.ShutdownGracePeriod)
+	assert.Equal(t, 5*time.Second, c.ReadHeaderTimeout)
+	assert.Equal(t, 10*time.Second, c.ReadTimeout)
+	assert.Equal(t, 15*time.Second, c.WriteTimeout)
+	assert.Equal(t, 2*time.Minute, c.IdleTimeout)
+	assert.Equal(t, int64(DefaultMaxRequestBytes), c.MaxRequestBytes)
+	assert.Equal(t, 1500*time.Millisecond, c.AdmissionTimeout)
+
+	c, err = Parse(nil, getenv(map[string]string{"TLS": "true"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ":443", c.Addr)
+}
+
+func TestParseEnvAndFlags(t *testing.T) {
+	env := map[string]string{
+		"CONFIG_FILE":           "/config/env.yaml",
+		"TLS":                   "true",
+		"LISTEN_ADDR":           ":8443",
+		"TLS_CERT_FILE":         "/certs/env.crt",
+		"TLS_MIN_VERSION":       "1.3",
+		"TLS_CIPHER_SUITES":     "a, b",
+		"CLIENT_CA_FILE":        "/certs/ca.crt",
+		"CLIENT_ALLOWED_NAMES":  "kube-apiserver,system:kube-apiserver",
+		"SHUTDOWN_DELAY":        "0s",
+		"SHUTDOWN_GRACE_PERIOD": "not a duration",
+		"MAX_REQUEST_BYTES":     "1048576",
+		"ADMISSION_TIMEOUT":     "8s",
+	}
+	args := []string{
+		"-config-file", "/config/flag.yaml",
+		"-tls-cert-file", "/certs/flag.crt",
+		"-tls-min-version", "1.2",
+		"-shutdown-grace-period", "1m",
+		"-tls-cipher-suites", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	}
+
+	c, err := Parse(args, getenv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "/config/flag.yaml", c.ConfigFile)
+	assert.Equal(t, ":8443", c.Addr)
+	assert.True(t, c.TLS)
+	assert.Equal(t, "/certs/flag.crt", c.CertFile)
+	assert.Equal(t, "/etc/admission-webhook/tls/tls.key", c.KeyFile)
+	assert.Equal(t, "1.2", c.MinTLSVersion)
+	assert.Equal(t, []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, c.CipherSuites)
+	assert.Equal(t, "/certs/ca.crt", c.ClientCAFile)
+	assert.Equal(t, []string{"kube-apiserver", "system:kube-apiserver"}, c.ClientAllowedNames)
+	assert.Equal(t, time.Duration(0), c.ShutdownDelay)
+	assert.Equal(t, time.Minute, c.ShutdownGracePeriod)
+	assert.Equal(t, int64(1<<20), c.MaxRequestBytes)
+	assert.Equal(t, 8*time.Second, c.AdmissionTimeout)
+}
+
+func TestParseCertBootstrap(t *testing.T) {
+	c, err := Parse([]string{"-cert-bootstrap", "-namespace", "webhooks", "-webhook-configs", "a,b"}, getenv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, c.TLS)
+	assert.Equal(t, ":443", c.Addr)
+	assert.Equal(t, filepath.Join(os.TempDir(), "simple-kubernetes-webhook", "tls.crt"), c.CertFile)
+	assert.Equal(t, []string{"a", "b"}, c.WebhookConfigs)
+	assert.Equal(t, []string{
+		"simple-kubernetes-webhook",
+		"simple-kubernetes-webhook.webhooks",
+		"simple-kubernetes-webhook.webhooks.svc",
+	}, c.DNSNames())
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{name: "unknown flag", args: []string{"-port", "443"}},
+		{name: "invalid boolean env", env: map[string]string{"TLS": "yes please"}},
+		{name: "invalid duration env", env: map[string]string{"SHUTDOWN_GRACE_PERIOD": "20"}},
+		{name: "negative duration", args: []string{"-shutdown-delay", "-1s"}},
+		{name: "invalid integer env", env: map[string]string{"MAX_REQUEST_BYTES": "6MiB"}},
+		{name: "no max request size", args: []string{"-max-request-bytes", "0"}},
+		{name: "no admission timeout", args: []string{"-admission-timeout", "0"}},
+		{name: "write timeout under admission timeout", args: []string{"-write-timeout", "2s", "-admission-timeout", "2s"}},
+		{name: "unsupported TLS version", args: []string{"-tls-min-version", "1.4"}},
+		{name: "unknown cipher suite", args: []string{"-tls-cipher-suites", "TLS_NULL"}},
+		{name: "insecure cipher suite", args: []string{"-tls-cipher-suites", "TLS_RSA_WITH_RC4_128_SHA"}},
+		{name: "client CA without TLS", args: []string{"-client-ca-file", "ca.crt"}},
+		{name: "client allowed names without CA", args: []string{"-tls", "-client-allowed-names", "kube-apiserver"}},
+		{name: "bootstrap without namespace", args: []string{"-cert-bootstrap"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args, getenv(tt.env))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseInvalidEnvOverridden(t *testing.T) {
+	c, err := Parse([]string{"-tls=false"}, getenv(map[string]string{"TLS": "yes please"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, c.TLS)
+}
+
+func TestCipherSuites(t *testing.T) {
+	suites, err := cipherSuites([]string{"TLS_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, suites)
+}
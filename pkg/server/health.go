@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Health reports the liveness and readiness of the webhook. The webhook is
+// live while all checks pass, and ready while it is live and not shutting
+// down. The zero value is live and ready.
+type Health struct {
+	shuttingDown int32
+	checks       []healthCheck
+}
+
+// healthCheck is a named liveness check
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// AddCheck adds a liveness check, checks must be added before serving
+func (h *Health) AddCheck(name string, check func() error) {
+	h.checks = append(h.checks, healthCheck{name: name, check: check})
+}
+
+// ShutDown reports the webhook unready from now on
+func (h *Health) ShutDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+// ServeLivez writes OK if all liveness checks pass, the failed checks along
+// with 503 Service Unavailable otherwise
+func (h *Health) ServeLivez(w http.ResponseWriter, r *http.Request) {
+	if failed := failures(h.checks); len(failed) > 0 {
+		http.Error(w, strings.Join(failed, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "OK")
+}
+
+// ServeReadyz writes OK if the webhook is live and not shutting down, why it
+// is not ready along with 503 Service Unavailable otherwise
+func (h *Health) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	failed := failures(h.checks)
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		failed = append([]string{"shutting down"}, failed...)
+	}
+	if len(failed) > 0 {
+		http.Error(w, strings.Join(failed, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "OK")
+}
+
+// failures returns the failure of each failed check
+func failures(checks []healthCheck) []string {
+	var failed []string
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+	return failed
+}
+
+// CertificateCheck returns a check failing once the certificate whose expiry
+// is returned by notAfter has expired. Expiring certificates don't fail it:
+// replicas usually share a certificate, failing them all at once ahead of
+// expiry would be an outage of its own.
+func CertificateCheck(notAfter func() time.Time) func() error {
+	return func() error {
+		expiry := notAfter()
+		if time.Now().After(expiry) {
+			return fmt.Errorf("expired on %s", expiry.UTC().Format(time.RFC3339))
+		}
+		return nil
+	}
+}
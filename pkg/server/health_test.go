@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func probe(handler http.HandlerFunc) (int, string) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w.Code, w.Body.String()
+}
+
+func TestHealth(t *testing.T) {
+	h := &Health{}
+	var configErr, certErr error
+	h.AddCheck("config", func() error { return configErr })
+	h.AddCheck("certificate", func() error { return certErr })
+
+	code, body := probe(h.ServeLivez)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "OK", body)
+	code, _ = probe(h.ServeReadyz)
+	assert.Equal(t, http.StatusOK, code)
+
+	certErr = fmt.Errorf("expired")
+	code, body = probe(h.ServeLivez)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "certificate: expired\n", body)
+	code, body = probe(h.ServeReadyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "certificate: expired\n", body)
+
+	configErr = fmt.Errorf("not loaded")
+	code, body = probe(h.ServeLivez)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "config: not loaded\ncertificate: expired\n", body)
+
+	configErr, certErr = nil, nil
+	h.ShutDown()
+	code, _ = probe(h.ServeLivez)
+	assert.Equal(t, http.StatusOK, code)
+	code, body = probe(h.ServeReadyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "shutting down\n", body)
+}
+
+func TestCertificateCheck(t *testing.T) {
+	// expiring certificates still pass
+	check := CertificateCheck(func() time.Time { return time.Now().Add(time.Hour) })
+	assert.Nil(t, check())
+
+	check = CertificateCheck(func() time.Time { return time.Now().Add(-time.Hour) })
+	assert.Error(t, check())
+}
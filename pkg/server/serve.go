@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HTTPServer returns an HTTP server with the configured timeouts, serving
+// handler, the default mux if nil
+func (c *Config) HTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              c.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+	}
+}
+
+// Serve serves srv on the listener until ctx is done, over TLS if enabled,
+// then shuts down gracefully: the webhook is reported unready while requests
+// are still served for the shutdown delay, so that the API server stops
+// calling it, then in-flight requests are given the shutdown grace period to
+// complete. It returns nil once shut down.
+func (c *Config) Serve(ctx context.Context, logger logrus.FieldLogger, ln net.Listener, srv *http.Server, health *Health) error {
+	errs := make(chan error, 1)
+	go func() {
+		if c.TLS {
+			errs <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Infof("shutting down, serving for %s while unready", c.ShutdownDelay)
+	health.ShutDown()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(c.ShutdownDelay):
+	}
+
+	logger.Infof("draining in-flight requests for up to %s", c.ShutdownGracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.ShutdownGracePeriod)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	logger.Info("shut down")
+
+	return nil
+}
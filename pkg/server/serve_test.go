@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPServer(t *testing.T) {
+	c, err := Parse([]string{"-listen-addr", ":8443", "-read-timeout", "3s"}, getenv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := c.HTTPServer(ok)
+	assert.Equal(t, ":8443", srv.Addr)
+	assert.Equal(t, 5*time.Second, srv.ReadHeaderTimeout)
+	assert.Equal(t, 3*time.Second, srv.ReadTimeout)
+	assert.Equal(t, 15*time.Second, srv.WriteTimeout)
+	assert.Equal(t, 2*time.Minute, srv.IdleTimeout)
+}
+
+func TestServeShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+
+	health := &Health{}
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", health.ServeReadyz)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	c := &Config{ShutdownDelay: 100 * time.Millisecond, ShutdownGracePeriod: 5 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- c.Serve(ctx, logger(), ln, &http.Server{Handler: mux}, health) }()
+
+	resp, err := http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	slow := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	// requests are still served while unready during the shutdown delay
+	time.Sleep(20 * time.Millisecond)
+	resp, err = http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// in-flight requests are drained before returning
+	select {
+	case <-served:
+		t.Fatal("returned before draining in-flight requests")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	assert.Equal(t, http.StatusOK, <-slow)
+	assert.Nil(t, <-served)
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	c := &Config{ShutdownGracePeriod: 50 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- c.Serve(ctx, logger(), ln, &http.Server{Handler: handler}, &Health{}) }()
+
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+	cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, <-served)
+}
+
+func TestServeError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	c := &Config{}
+	err = c.Serve(context.Background(), logger(), ln, &http.Server{}, &Health{})
+	assert.Error(t, err)
+}
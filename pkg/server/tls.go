@@ -0,0 +1,30 @@
+package server
+
+import "crypto/tls"
+
+// TLSConfig returns the TLS config of the server, serving the certificate
+// returned by getCertificate. When a client CA file is set, client
+// certificates are requested but only verified by handlers wrapped with
+// ClientAuth.Require, so that kubelet probes can still reach health endpoints
+// and rejected callers are reported rather than failing the handshake.
+func (c *Config) TLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	version, err := tlsVersion(c.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := cipherSuites(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		GetCertificate: getCertificate,
+		MinVersion:     version,
+		CipherSuites:   suites,
+	}
+	if c.ClientCAFile != "" {
+		cfg.ClientAuth = tls.RequestClientCert
+	}
+
+	return cfg, nil
+}
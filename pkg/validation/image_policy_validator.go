@@ -0,0 +1,141 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/image"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// imagePolicyValidator is a container for validating the images of pod
+// containers, init containers and ephemeral containers
+type imagePolicyValidator struct {
+	Logger logrus.FieldLogger
+	// Registries are the registries or repositories images are allowed from,
+	// images from any registry are allowed if empty
+	Registries []string
+	// RequireDigest requires images to be pinned by digest
+	RequireDigest bool
+}
+
+// imagePolicyValidator implements the podValidator interface
+var _ podValidator = (*imagePolicyValidator)(nil)
+
+// newImagePolicyValidator returns an imagePolicyValidator allowing images from
+// the registries listed under `registries` in the rule params, and requiring
+// digests if `requireDigest` is set
+func newImagePolicyValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	params := struct {
+		Registries    []string `json:"registries"`
+		RequireDigest bool     `json:"requireDigest"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	for _, r := range params.Registries {
+		if r == "" || strings.HasSuffix(r, "/") {
+			return nil, fmt.Errorf("invalid registry %q in %q", r, rule.Name)
+		}
+	}
+
+	return imagePolicyValidator{Logger: logger, Registries: params.Registries, RequireDigest: params.RequireDigest}, nil
+}
+
+// Name returns the name of imagePolicyValidator
+func (i imagePolicyValidator) Name() string {
+	return "image_policy"
+}
+
+// Validate inspects the images of a given pod and returns validation. The
+// returned validation is only valid if all images are from an allowed
+// registry, are tagged with another tag than latest or pinned by digest, and
+// are pinned by digest if required. On UPDATE only changed images are
+// validated, which covers ephemeral containers added to a running pod.
+func (i imagePolicyValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	switch req.Operation {
+	case admissionv1.Create, admissionv1.Update:
+	default:
+		return validation{Valid: true, Reason: "nothing to validate"}, nil
+	}
+
+	old := map[string]string{}
+	if oldPod := req.OldPod(); oldPod != nil {
+		old = podImages(oldPod)
+	}
+
+	var reasons []string
+	for _, c := range podContainers(pod) {
+		if prev, ok := old[c.Name]; ok && prev == c.Image {
+			continue
+		}
+		if reason := i.violation(c.Image); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("container %q image %q %s", c.Name, c.Image, reason))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return validation{Valid: false, Reason: strings.Join(reasons, ", ")}, nil
+	}
+
+	return validation{Valid: true, Reason: "valid images"}, nil
+}
+
+// violation returns why the image is not allowed, or an empty string
+func (i imagePolicyValidator) violation(img string) string {
+	ref, err := image.Parse(img)
+	if err != nil {
+		return fmt.Sprintf("is invalid: %v", err)
+	}
+
+	if len(i.Registries) > 0 {
+		allowed := false
+		for _, r := range i.Registries {
+			if ref.HasPrefix(r) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("is not from an allowed registry (%s)", strings.Join(i.Registries, ", "))
+		}
+	}
+
+	switch {
+	case i.RequireDigest && ref.Digest == "":
+		return "is not pinned by digest"
+	case ref.Latest() && ref.Tag == "":
+		return "has no tag"
+	case ref.Latest():
+		return "uses the latest tag"
+	}
+
+	return ""
+}
+
+// podContainers returns the init containers, containers and ephemeral
+// containers of the pod
+func podContainers(pod *corev1.Pod) []corev1.Container {
+	var containers []corev1.Container
+	containers = append(containers, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+	for _, c := range pod.Spec.EphemeralContainers {
+		containers = append(containers, corev1.Container(c.EphemeralContainerCommon))
+	}
+	return containers
+}
+
+// podImages returns the images of all containers of the pod by container name
+func podImages(pod *corev1.Pod) map[string]string {
+	images := map[string]string{}
+	for _, c := range podContainers(pod) {
+		images[c.Name] = c.Image
+	}
+	return images
+}
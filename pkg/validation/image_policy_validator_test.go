@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestImagePolicyValidatorValidate(t *testing.T) {
+	iv := imagePolicyValidator{Logger: logger(), Registries: []string{"gcr.io/acme", "docker.io/library"}}
+
+	for _, tc := range []struct {
+		name   string
+		image  string
+		valid  bool
+		reason string
+	}{
+		{"tagged", "gcr.io/acme/app:v1", true, "valid images"},
+		{"docker library", "nginx:1.21", true, "valid images"},
+		{"pinned", "gcr.io/acme/app@" + digest, true, "valid images"},
+		{"latest pinned", "gcr.io/acme/app:latest@" + digest, true, "valid images"},
+		{"untagged", "gcr.io/acme/app", false, `container "app" image "gcr.io/acme/app" has no tag`},
+		{"latest", "nginx:latest", false, `container "app" image "nginx:latest" uses the latest tag`},
+		{"registry", "quay.io/acme/app:v1", false,
+			`container "app" image "quay.io/acme/app:v1" is not from an allowed registry (gcr.io/acme, docker.io/library)`},
+		{"repository prefix", "gcr.io/acme-corp/app:v1", false,
+			`container "app" image "gcr.io/acme-corp/app:v1" is not from an allowed registry (gcr.io/acme, docker.io/library)`},
+		{"invalid", "gcr.io/acme/App:v1", false,
+			`container "app" image "gcr.io/acme/App:v1" is invalid: invalid repository "acme/App" in image "gcr.io/acme/App:v1"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: tc.image}}}}
+
+			v, err := iv.Validate(context.Background(), createRequest(), pod)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.valid, v.Valid)
+			assert.Equal(t, tc.reason, v.Reason)
+		})
+	}
+}
+
+func TestImagePolicyValidatorDigest(t *testing.T) {
+	rule := config.Rule{Name: "image_policy", Params: json.RawMessage(`{"requireDigest":true}`)}
+	iv, err := newImagePolicyValidator(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: "app"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init", Image: "busybox@" + digest}},
+			Containers:     []corev1.Container{{Name: "app", Image: "acme/app:v1"}},
+		},
+	}
+
+	v, err := iv.Validate(context.Background(), createRequest(), pod)
+	assert.Nil(t, err)
+	assert.False(t, v.Valid)
+	assert.Equal(t, `container "app" image "acme/app:v1" is not pinned by digest`, v.Reason)
+
+	// unchanged images are not validated on update, added ephemeral
+	// containers are
+	updated := pod.DeepCopy()
+	updated.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", Image: "busybox:1.34"},
+	}}
+	req := &request.Request{Operation: admissionv1.Update, OldObject: pod}
+	v, err = iv.Validate(context.Background(), req, updated)
+	assert.Nil(t, err)
+	assert.False(t, v.Valid)
+	assert.Equal(t, `container "debug" image "busybox:1.34" is not pinned by digest`, v.Reason)
+}
+
+func TestNewImagePolicyValidatorInvalid(t *testing.T) {
+	for name, params := range map[string]string{
+		"empty registry":    `{"registries":[""]}`,
+		"trailing slash":    `{"registries":["gcr.io/"]}`,
+		"unknown parameter": `{"allowLatest":true}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newImagePolicyValidator(logger(), config.Rule{Name: "image_policy", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
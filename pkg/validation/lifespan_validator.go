@@ -0,0 +1,72 @@
+package validation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/lifespan"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// lifespanValidator is a container for validating the lifespan labels of
+// pods, it is the companion of the min_lifespan mutation
+type lifespanValidator struct {
+	Logger   logrus.FieldLogger
+	Families []lifespan.Family
+}
+
+// lifespanValidator implements the podValidator interface
+var _ podValidator = (*lifespanValidator)(nil)
+
+// newLifespanValidator returns a lifespanValidator configured with the
+// lifespan families listed under `families` in the rule params, they must be
+// the same as the min_lifespan mutation ones, see lifespan.CheckShared
+func newLifespanValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	families, err := lifespan.Families(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	return lifespanValidator{Logger: logger, Families: families}, nil
+}
+
+// Name returns the name of lifespanValidator
+func (l lifespanValidator) Name() string {
+	return lifespan.ValidationName
+}
+
+// Validate inspects the lifespan labels of a given pod and returns
+// validation. The returned validation is only valid if all lifespan labels
+// are integers within the range of their family. On UPDATE only changed
+// labels are validated.
+func (l lifespanValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	switch req.Operation {
+	case admissionv1.Create, admissionv1.Update:
+	default:
+		return validation{Valid: true, Reason: "nothing to validate"}, nil
+	}
+
+	var reasons []string
+	for _, label := range lifespan.Labels(l.Families, pod.Labels) {
+		if label.Value == "" {
+			continue
+		}
+		if old := req.OldPod(); old != nil && old.Labels[label.LabelKey] == label.Value {
+			continue
+		}
+
+		if _, err := label.Lifespan(label.Value); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+
+	if len(reasons) > 0 {
+		return validation{Valid: false, Reason: strings.Join(reasons, ", ")}, nil
+	}
+
+	return validation{Valid: true, Reason: "valid lifespan labels"}, nil
+}
@@ -0,0 +1,74 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/lifespan"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLifespanValidatorValidate(t *testing.T) {
+	lv := lifespanValidator{Logger: logger(), Families: []lifespan.Family{lifespan.Default()}}
+
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		valid  bool
+		reason string
+	}{
+		{"no label", nil, true, "valid lifespan labels"},
+		{"valid", map[string]string{"acme.com/lifespan-requested": "7"}, true, "valid lifespan labels"},
+		{"not an integer", map[string]string{"acme.com/lifespan-requested": "seven"}, false,
+			`lifespan label acme.com/lifespan-requested="seven" is not an integer`},
+		{"negative", map[string]string{"acme.com/lifespan-requested": "-1"}, false,
+			`lifespan label acme.com/lifespan-requested="-1" is negative`},
+		{"out of range", map[string]string{"acme.com/lifespan-requested": "15"}, false,
+			`lifespan label acme.com/lifespan-requested="15" is greater than the max lifespan of 14 days`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "lifespan", Labels: tc.labels}}
+
+			v, err := lv.Validate(context.Background(), createRequest(), pod)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.valid, v.Valid)
+			assert.Equal(t, tc.reason, v.Reason)
+		})
+	}
+}
+
+func TestLifespanValidatorFamilies(t *testing.T) {
+	rule := config.Rule{
+		Name: "lifespan_label",
+		Params: json.RawMessage(`{"families":[
+			{"labelKey":"acme.com/lifespan","taintKey":"acme.com/remaining","maxAge":30},
+			{"labelKey":"acme.com/spot-lifespan","taintKey":"acme.com/spot-remaining","maxAge":3}
+		]}`),
+	}
+	lv, err := newLifespanValidator(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Labels: map[string]string{
+		"acme.com/lifespan":      "30",
+		"acme.com/spot-lifespan": "4",
+	}}}
+
+	v, err := lv.Validate(context.Background(), createRequest(), pod)
+	assert.Nil(t, err)
+	assert.False(t, v.Valid)
+	assert.Equal(t, `lifespan label acme.com/spot-lifespan="4" is greater than the max lifespan of 3 days`, v.Reason)
+
+	// unchanged labels are not validated on update
+	req := &request.Request{Operation: admissionv1.Update, OldObject: pod.DeepCopy()}
+	v, err = lv.Validate(context.Background(), req, pod)
+	assert.Nil(t, err)
+	assert.True(t, v.Valid)
+}
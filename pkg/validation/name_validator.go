@@ -1,39 +1,137 @@
 package validation
 
 import (
+	"context"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 )
 
+// defaultDenylist are the words pod names must not contain when none are
+// configured
+var defaultDenylist = []string{"offensive"}
+
 // nameValidator is a container for validating the name of pods
 type nameValidator struct {
 	Logger logrus.FieldLogger
+	// Denylist are the words names must not contain, defaultDenylist if nil
+	Denylist []string
+	// DeniedPatterns are the regular expressions names must not match
+	DeniedPatterns []*regexp.Regexp
+	// AllowedPrefixes are the prefixes names must start with, any name is
+	// allowed if empty
+	AllowedPrefixes []string
 }
 
 // nameValidator implements the podValidator interface
 var _ podValidator = (*nameValidator)(nil)
 
+// newNameValidator returns a nameValidator configured with the words listed
+// under `denylist`, the regular expressions listed under `deniedPatterns` and
+// the prefixes listed under `allowedPrefixes` in the rule params. All are
+// matched case insensitively.
+func newNameValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	params := struct {
+		Denylist        []string `json:"denylist"`
+		DeniedPatterns  []string `json:"deniedPatterns"`
+		AllowedPrefixes []string `json:"allowedPrefixes"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	for _, w := range params.Denylist {
+		if w == "" {
+			return nil, fmt.Errorf("empty denied word in %q", rule.Name)
+		}
+	}
+
+	patterns := make([]*regexp.Regexp, len(params.DeniedPatterns))
+	for i, p := range params.DeniedPatterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denied pattern %q in %q: %v", p, rule.Name, err)
+		}
+		patterns[i] = re
+	}
+
+	return nameValidator{
+		Logger:          logger,
+		Denylist:        params.Denylist,
+		DeniedPatterns:  patterns,
+		AllowedPrefixes: params.AllowedPrefixes,
+	}, nil
+}
+
 // Name returns the name of nameValidator
 func (n nameValidator) Name() string {
 	return "name_validator"
 }
 
 // Validate inspects the name of a given pod and returns validation.
-// The returned validation is only valid if the pod name does not contain some
-// bad string.
-func (n nameValidator) Validate(pod *corev1.Pod) (validation, error) {
-	badString := "offensive"
-
-	if strings.Contains(pod.Name, badString) {
-		v := validation{
-			Valid:  false,
-			Reason: fmt.Sprintf("pod name contains %q", badString),
+// The returned validation is only valid if the pod name and generate name
+// don't contain a denied word, don't match a denied pattern and start with an
+// allowed prefix. Pods created by controllers and workload pod templates only
+// have a generate name at admission time. Names are immutable so only new
+// pods are validated.
+func (n nameValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	if req.Operation != admissionv1.Create {
+		return validation{Valid: true, Reason: "name already validated on create"}, nil
+	}
+
+	var reasons []string
+	for _, name := range []struct{ kind, value string }{
+		{"pod name", pod.Name},
+		{"pod generate name", pod.GenerateName},
+	} {
+		if name.value == "" {
+			continue
+		}
+		if reason := n.violation(name.value); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("%s %s", name.kind, reason))
 		}
-		return v, nil
+	}
+
+	if len(reasons) > 0 {
+		return validation{Valid: false, Reason: strings.Join(reasons, ", ")}, nil
 	}
 
 	return validation{Valid: true, Reason: "valid name"}, nil
 }
+
+// violation returns why the name is not allowed, or an empty string
+func (n nameValidator) violation(name string) string {
+	lower := strings.ToLower(name)
+
+	denylist := n.Denylist
+	if denylist == nil {
+		denylist = defaultDenylist
+	}
+	for _, w := range denylist {
+		if strings.Contains(lower, strings.ToLower(w)) {
+			return fmt.Sprintf("contains %q", w)
+		}
+	}
+
+	for _, p := range n.DeniedPatterns {
+		if p.MatchString(name) {
+			return fmt.Sprintf("matches denied pattern %s", strings.TrimPrefix(p.String(), "(?i)"))
+		}
+	}
+
+	if len(n.AllowedPrefixes) == 0 {
+		return ""
+	}
+	for _, p := range n.AllowedPrefixes {
+		if strings.HasPrefix(lower, strings.ToLower(p)) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("does not start with one of %s", strings.Join(n.AllowedPrefixes, ", "))
+}
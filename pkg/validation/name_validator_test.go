@@ -1,8 +1,11 @@
 package validation
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -22,7 +25,7 @@ func TestNameValidatorValidate(t *testing.T) {
 			},
 		}
 
-		v, err := nameValidator{logger()}.Validate(pod)
+		v, err := nameValidator{Logger: logger()}.Validate(context.Background(), createRequest(), pod)
 		assert.Nil(t, err)
 		assert.True(t, v.Valid)
 	})
@@ -40,8 +43,61 @@ func TestNameValidatorValidate(t *testing.T) {
 			},
 		}
 
-		v, err := nameValidator{logger()}.Validate(pod)
+		v, err := nameValidator{Logger: logger()}.Validate(context.Background(), createRequest(), pod)
 		assert.Nil(t, err)
 		assert.False(t, v.Valid)
 	})
 }
+
+func TestNameValidatorPolicy(t *testing.T) {
+	rule := config.Rule{
+		Name: "name_validator",
+		Params: json.RawMessage(`{
+			"denylist": ["badword"],
+			"deniedPatterns": ["-tmp$", "^test-"],
+			"allowedPrefixes": ["payments-", "billing-"]
+		}`),
+	}
+	nv, err := newNameValidator(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		podName      string
+		generateName string
+		valid        bool
+		reason       string
+	}{
+		{"allowed", "payments-api", "", true, "valid name"},
+		{"allowed generate name", "", "Billing-worker-", true, "valid name"},
+		{"denied word", "payments-BadWord", "", false, `pod name contains "badword"`},
+		{"denied pattern", "payments-api-TMP", "", false, "pod name matches denied pattern -tmp$"},
+		{"generate name prefix", "", "web-", false, "pod generate name does not start with one of payments-, billing-"},
+		{"both names", "test-payments", "badword-", false,
+			`pod name matches denied pattern ^test-, pod generate name contains "badword"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: tc.podName, GenerateName: tc.generateName}}
+
+			v, err := nv.Validate(context.Background(), createRequest(), pod)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.valid, v.Valid)
+			assert.Equal(t, tc.reason, v.Reason)
+		})
+	}
+}
+
+func TestNewNameValidatorInvalid(t *testing.T) {
+	for name, params := range map[string]string{
+		"empty word":    `{"denylist":[""]}`,
+		"bad pattern":   `{"deniedPatterns":["("]}`,
+		"unknown field": `{"allowlist":["a"]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newNameValidator(logger(), config.Rule{Name: "name_validator", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
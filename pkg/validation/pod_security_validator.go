@@ -0,0 +1,363 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The pod security validators each enforce a single control of a security
+// profile, so that they can be enabled, scoped and reported on their own
+
+// privilegedValidator is a container for denying privileged containers
+type privilegedValidator struct {
+	Logger logrus.FieldLogger
+}
+
+// hostNamespacesValidator is a container for denying pods sharing the host
+// network, PID or IPC namespace
+type hostNamespacesValidator struct {
+	Logger logrus.FieldLogger
+}
+
+// hostPathValidator is a container for denying hostPath volumes outside of
+// the allowed paths
+type hostPathValidator struct {
+	Logger  logrus.FieldLogger
+	Allowed []allowedHostPath
+}
+
+// allowedHostPath is a host path prefix hostPath volumes may mount, as in a
+// PodSecurityPolicy
+type allowedHostPath struct {
+	// PathPrefix matches whole path components, /var/log allows /var/log/app
+	// but not /var/logs
+	PathPrefix string `json:"pathPrefix"`
+	// ReadOnly requires all containers to mount the volume read-only
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// capabilitiesValidator is a container for denying added capabilities outside
+// of the allowed capabilities
+type capabilitiesValidator struct {
+	Logger  logrus.FieldLogger
+	Allowed []corev1.Capability
+}
+
+// runAsNonRootValidator is a container for denying containers that may run
+// as root
+type runAsNonRootValidator struct {
+	Logger logrus.FieldLogger
+}
+
+// readOnlyRootFilesystemValidator is a container for denying containers with
+// a writable root filesystem
+type readOnlyRootFilesystemValidator struct {
+	Logger logrus.FieldLogger
+}
+
+// the pod security validators implement the podValidator interface
+var (
+	_ podValidator = (*privilegedValidator)(nil)
+	_ podValidator = (*hostNamespacesValidator)(nil)
+	_ podValidator = (*hostPathValidator)(nil)
+	_ podValidator = (*capabilitiesValidator)(nil)
+	_ podValidator = (*runAsNonRootValidator)(nil)
+	_ podValidator = (*readOnlyRootFilesystemValidator)(nil)
+)
+
+// newPrivilegedValidator returns a privilegedValidator, it takes no params
+func newPrivilegedValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	if err := rule.DecodeParams(&struct{}{}); err != nil {
+		return nil, err
+	}
+
+	return privilegedValidator{Logger: logger}, nil
+}
+
+// newHostNamespacesValidator returns a hostNamespacesValidator, it takes no
+// params
+func newHostNamespacesValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	if err := rule.DecodeParams(&struct{}{}); err != nil {
+		return nil, err
+	}
+
+	return hostNamespacesValidator{Logger: logger}, nil
+}
+
+// newHostPathValidator returns a hostPathValidator allowing the host paths
+// listed under `allowed` in the rule params, no host path is allowed if empty
+func newHostPathValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	params := struct {
+		Allowed []allowedHostPath `json:"allowed"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	for i, a := range params.Allowed {
+		if !path.IsAbs(a.PathPrefix) {
+			return nil, fmt.Errorf("allowed host path %q in %q is not absolute", a.PathPrefix, rule.Name)
+		}
+		params.Allowed[i].PathPrefix = path.Clean(a.PathPrefix)
+	}
+
+	return hostPathValidator{Logger: logger, Allowed: params.Allowed}, nil
+}
+
+// newCapabilitiesValidator returns a capabilitiesValidator allowing the
+// capabilities listed under `allowed` in the rule params, no capability may
+// be added if empty
+func newCapabilitiesValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	params := struct {
+		Allowed []corev1.Capability `json:"allowed"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	for _, c := range params.Allowed {
+		if c == "" {
+			return nil, fmt.Errorf("empty allowed capability in %q", rule.Name)
+		}
+	}
+
+	return capabilitiesValidator{Logger: logger, Allowed: params.Allowed}, nil
+}
+
+// newRunAsNonRootValidator returns a runAsNonRootValidator, it takes no
+// params
+func newRunAsNonRootValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	if err := rule.DecodeParams(&struct{}{}); err != nil {
+		return nil, err
+	}
+
+	return runAsNonRootValidator{Logger: logger}, nil
+}
+
+// newReadOnlyRootFilesystemValidator returns a
+// readOnlyRootFilesystemValidator, it takes no params
+func newReadOnlyRootFilesystemValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	if err := rule.DecodeParams(&struct{}{}); err != nil {
+		return nil, err
+	}
+
+	return readOnlyRootFilesystemValidator{Logger: logger}, nil
+}
+
+// Name returns the name of privilegedValidator
+func (p privilegedValidator) Name() string {
+	return "privileged"
+}
+
+// Name returns the name of hostNamespacesValidator
+func (h hostNamespacesValidator) Name() string {
+	return "host_namespaces"
+}
+
+// Name returns the name of hostPathValidator
+func (h hostPathValidator) Name() string {
+	return "host_path"
+}
+
+// Name returns the name of capabilitiesValidator
+func (c capabilitiesValidator) Name() string {
+	return "capabilities"
+}
+
+// Name returns the name of runAsNonRootValidator
+func (r runAsNonRootValidator) Name() string {
+	return "run_as_non_root"
+}
+
+// Name returns the name of readOnlyRootFilesystemValidator
+func (r readOnlyRootFilesystemValidator) Name() string {
+	return "read_only_root_filesystem"
+}
+
+// Validate returns a validation only valid if no container, init container
+// or ephemeral container of the pod is privileged
+func (p privilegedValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	return validateViolations(req, pod, p.violations, "no privileged container"), nil
+}
+
+// violations returns a reason for each privileged container
+func (p privilegedValidator) violations(pod *corev1.Pod) []string {
+	var reasons []string
+	for _, c := range podContainers(pod) {
+		if sc := c.SecurityContext; sc != nil && sc.Privileged != nil && *sc.Privileged {
+			reasons = append(reasons, fmt.Sprintf("container %q is privileged", c.Name))
+		}
+	}
+	return reasons
+}
+
+// Validate returns a validation only valid if the pod doesn't share the host
+// network, PID or IPC namespace
+func (h hostNamespacesValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	return validateViolations(req, pod, h.violations, "no host namespace"), nil
+}
+
+// violations returns a reason for each host namespace the pod shares
+func (h hostNamespacesValidator) violations(pod *corev1.Pod) []string {
+	var reasons []string
+	if pod.Spec.HostNetwork {
+		reasons = append(reasons, "pod uses the host network")
+	}
+	if pod.Spec.HostPID {
+		reasons = append(reasons, "pod uses the host PID namespace")
+	}
+	if pod.Spec.HostIPC {
+		reasons = append(reasons, "pod uses the host IPC namespace")
+	}
+	return reasons
+}
+
+// Validate returns a validation only valid if all hostPath volumes of the pod
+// are within an allowed path, and are mounted read-only by all containers if
+// the path is only allowed read-only
+func (h hostPathValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	return validateViolations(req, pod, h.violations, "allowed host paths"), nil
+}
+
+// violations returns a reason for each host path that is not allowed or
+// mounted read-write while only allowed read-only
+func (h hostPathValidator) violations(pod *corev1.Pod) []string {
+	var reasons []string
+	for _, v := range pod.Spec.Volumes {
+		if v.HostPath == nil {
+			continue
+		}
+
+		allowed, readOnly := h.allowed(v.HostPath.Path)
+		if !allowed {
+			reasons = append(reasons, fmt.Sprintf("volume %q mounts host path %q which is not allowed", v.Name, v.HostPath.Path))
+			continue
+		}
+		if !readOnly {
+			continue
+		}
+
+		for _, c := range podContainers(pod) {
+			for _, m := range c.VolumeMounts {
+				if m.Name == v.Name && !m.ReadOnly {
+					reasons = append(reasons, fmt.Sprintf("container %q mounts host path %q read-write, it is only allowed read-only",
+						c.Name, v.HostPath.Path))
+				}
+			}
+		}
+	}
+	return reasons
+}
+
+// allowed returns whether the host path is allowed, and if it is only allowed
+// read-only. A path allowed read-write by any prefix is allowed read-write.
+func (h hostPathValidator) allowed(hostPath string) (allowed bool, readOnly bool) {
+	p := path.Clean(hostPath)
+	readOnly = true
+	for _, a := range h.Allowed {
+		if a.PathPrefix == "/" || p == a.PathPrefix || strings.HasPrefix(p, a.PathPrefix+"/") {
+			allowed = true
+			readOnly = readOnly && a.ReadOnly
+		}
+	}
+	return allowed, readOnly
+}
+
+// Validate returns a validation only valid if the containers, init containers
+// and ephemeral containers of the pod only add allowed capabilities.
+// Capabilities are compared case insensitively, with or without the CAP_
+// prefix.
+func (c capabilitiesValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	return validateViolations(req, pod, c.violations, "allowed capabilities"), nil
+}
+
+// violations returns a reason for each capability added and not allowed
+func (c capabilitiesValidator) violations(pod *corev1.Pod) []string {
+	allowed := map[string]bool{}
+	for _, a := range c.Allowed {
+		allowed[capability(a)] = true
+	}
+
+	var reasons []string
+	for _, container := range podContainers(pod) {
+		sc := container.SecurityContext
+		if sc == nil || sc.Capabilities == nil {
+			continue
+		}
+		for _, add := range sc.Capabilities.Add {
+			if !allowed[capability(add)] {
+				reasons = append(reasons, fmt.Sprintf("container %q adds capability %s which is not allowed", container.Name, add))
+			}
+		}
+	}
+	return reasons
+}
+
+// capability returns the canonical name of a capability, upper case without
+// the CAP_ prefix
+func capability(c corev1.Capability) string {
+	return strings.TrimPrefix(strings.ToUpper(string(c)), "CAP_")
+}
+
+// Validate returns a validation only valid if the containers, init containers
+// and ephemeral containers of the pod can't run as root: they must set
+// runAsNonRoot or a runAsUser other than 0, either themselves or through the
+// pod security context
+func (r runAsNonRootValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	return validateViolations(req, pod, r.violations, "containers run as non-root"), nil
+}
+
+// violations returns a reason for each container that may run as root
+func (r runAsNonRootValidator) violations(pod *corev1.Pod) []string {
+	var podUser *int64
+	var podNonRoot *bool
+	if psc := pod.Spec.SecurityContext; psc != nil {
+		podUser, podNonRoot = psc.RunAsUser, psc.RunAsNonRoot
+	}
+
+	var reasons []string
+	for _, c := range podContainers(pod) {
+		user, nonRoot := podUser, podNonRoot
+		if sc := c.SecurityContext; sc != nil {
+			if sc.RunAsUser != nil {
+				user = sc.RunAsUser
+			}
+			if sc.RunAsNonRoot != nil {
+				nonRoot = sc.RunAsNonRoot
+			}
+		}
+
+		switch {
+		case user != nil && *user == 0:
+			reasons = append(reasons, fmt.Sprintf("container %q runs as user 0", c.Name))
+		case user == nil && (nonRoot == nil || !*nonRoot):
+			reasons = append(reasons, fmt.Sprintf("container %q must set runAsNonRoot or a non-root runAsUser", c.Name))
+		}
+	}
+	return reasons
+}
+
+// Validate returns a validation only valid if the containers, init containers
+// and ephemeral containers of the pod have a read-only root filesystem
+func (r readOnlyRootFilesystemValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	return validateViolations(req, pod, r.violations, "read-only root filesystems"), nil
+}
+
+// violations returns a reason for each container with a writable root
+// filesystem
+func (r readOnlyRootFilesystemValidator) violations(pod *corev1.Pod) []string {
+	var reasons []string
+	for _, c := range podContainers(pod) {
+		if sc := c.SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+			reasons = append(reasons, fmt.Sprintf("container %q must set readOnlyRootFilesystem", c.Name))
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,176 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+// securityValidator returns the named pod security validator built with the
+// given params
+func securityValidator(t *testing.T, name, params string) podValidator {
+	t.Helper()
+	rule := config.Rule{Name: name}
+	if params != "" {
+		rule.Params = json.RawMessage(params)
+	}
+	pv, err := validations[name](logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pv
+}
+
+func TestPodSecurityValidators(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		validator string
+		params    string
+		spec      corev1.PodSpec
+		reason    string
+	}{
+		{
+			name:      "privileged",
+			validator: "privileged",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", SecurityContext: &corev1.SecurityContext{Privileged: boolPtr(true)}}},
+				Containers:     []corev1.Container{{Name: "app", SecurityContext: &corev1.SecurityContext{Privileged: boolPtr(false)}}},
+			},
+			reason: `container "init" is privileged`,
+		},
+		{
+			name:      "host namespaces",
+			validator: "host_namespaces",
+			spec:      corev1.PodSpec{HostNetwork: true, HostIPC: true},
+			reason:    "pod uses the host network, pod uses the host IPC namespace",
+		},
+		{
+			name:      "host path not allowed",
+			validator: "host_path",
+			params:    `{"allowed":[{"pathPrefix":"/var/log"}]}`,
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log/app"}}},
+				{Name: "other", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/logs"}}},
+			}},
+			reason: `volume "other" mounts host path "/var/logs" which is not allowed`,
+		},
+		{
+			name:      "host path read-only",
+			validator: "host_path",
+			params:    `{"allowed":[{"pathPrefix":"/etc/ssl/","readOnly":true}]}`,
+			spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{Name: "certs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/etc/ssl/certs"}}},
+				},
+				Containers: []corev1.Container{
+					{Name: "reader", VolumeMounts: []corev1.VolumeMount{{Name: "certs", ReadOnly: true}}},
+					{Name: "writer", VolumeMounts: []corev1.VolumeMount{{Name: "certs"}}},
+				},
+			},
+			reason: `container "writer" mounts host path "/etc/ssl/certs" read-write, it is only allowed read-only`,
+		},
+		{
+			name:      "capabilities",
+			validator: "capabilities",
+			params:    `{"allowed":["NET_BIND_SERVICE"]}`,
+			spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "app",
+				SecurityContext: &corev1.SecurityContext{Capabilities: &corev1.Capabilities{
+					Add: []corev1.Capability{"CAP_NET_BIND_SERVICE", "sys_admin"},
+				}},
+			}}},
+			reason: `container "app" adds capability sys_admin which is not allowed`,
+		},
+		{
+			name:      "run as root",
+			validator: "run_as_non_root",
+			spec: corev1.PodSpec{
+				SecurityContext: &corev1.PodSecurityContext{RunAsNonRoot: boolPtr(true)},
+				Containers: []corev1.Container{
+					{Name: "inherits"},
+					{Name: "user", SecurityContext: &corev1.SecurityContext{RunAsNonRoot: boolPtr(false), RunAsUser: int64Ptr(1000)}},
+					{Name: "root", SecurityContext: &corev1.SecurityContext{RunAsUser: int64Ptr(0)}},
+					{Name: "unset", SecurityContext: &corev1.SecurityContext{RunAsNonRoot: boolPtr(false)}},
+				},
+			},
+			reason: `container "root" runs as user 0, container "unset" must set runAsNonRoot or a non-root runAsUser`,
+		},
+		{
+			name:      "read-only root filesystem",
+			validator: "read_only_root_filesystem",
+			spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "app", SecurityContext: &corev1.SecurityContext{ReadOnlyRootFilesystem: boolPtr(true)}},
+				},
+				EphemeralContainers: []corev1.EphemeralContainer{{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug"},
+				}},
+			},
+			reason: `container "debug" must set readOnlyRootFilesystem`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pv := securityValidator(t, tc.validator, tc.params)
+			pod := &corev1.Pod{Spec: tc.spec}
+
+			v, err := pv.Validate(context.Background(), createRequest(), pod)
+			assert.Nil(t, err)
+			assert.False(t, v.Valid)
+			assert.Equal(t, tc.reason, v.Reason)
+
+			// violations the old pod already had are ignored on update
+			req := &request.Request{Operation: admissionv1.Update, OldObject: pod.DeepCopy()}
+			v, err = pv.Validate(context.Background(), req, pod)
+			assert.Nil(t, err)
+			assert.True(t, v.Valid)
+		})
+	}
+}
+
+func TestPodSecurityValidatorsUpdate(t *testing.T) {
+	pv := securityValidator(t, "privileged", "")
+
+	old := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app", SecurityContext: &corev1.SecurityContext{Privileged: boolPtr(true)}},
+	}}}
+	pod := old.DeepCopy()
+	pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:            "debug",
+			SecurityContext: &corev1.SecurityContext{Privileged: boolPtr(true)},
+		},
+	}}
+
+	v, err := pv.Validate(context.Background(), &request.Request{Operation: admissionv1.Update, OldObject: old}, pod)
+	assert.Nil(t, err)
+	assert.False(t, v.Valid)
+	assert.Equal(t, `container "debug" is privileged`, v.Reason)
+}
+
+func TestNewPodSecurityValidatorsInvalid(t *testing.T) {
+	for name, rule := range map[string]config.Rule{
+		"privileged params":     {Name: "privileged", Params: json.RawMessage(`{"allowed":true}`)},
+		"relative host path":    {Name: "host_path", Params: json.RawMessage(`{"allowed":[{"pathPrefix":"var/log"}]}`)},
+		"empty capability":      {Name: "capabilities", Params: json.RawMessage(`{"allowed":[""]}`)},
+		"run as non root param": {Name: "run_as_non_root", Params: json.RawMessage(`{"user":1000}`)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := validations[rule.Name](logger(), rule)
+			assert.Error(t, err)
+		})
+	}
+}
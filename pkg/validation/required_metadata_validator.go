@@ -0,0 +1,155 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// requiredMetadataValidator is a container for validating the labels and
+// annotations of pods
+type requiredMetadataValidator struct {
+	Logger      logrus.FieldLogger
+	Labels      []metadataRequirement
+	Annotations []metadataRequirement
+}
+
+// requiredMetadataValidator implements the podValidator interface
+var _ podValidator = (*requiredMetadataValidator)(nil)
+
+// metadataRequirement is a label or annotation pods must have, and the
+// constraints on its value
+type metadataRequirement struct {
+	Key string `json:"key"`
+	// Optional only constrains the value when the pod has the key
+	Optional bool `json:"optional,omitempty"`
+	// Pattern is a regular expression the whole value must match
+	Pattern string `json:"pattern,omitempty"`
+	// Values lists the allowed values
+	Values []string `json:"values,omitempty"`
+	// MaxLength is the maximum length of the value, unlimited if 0
+	MaxLength int `json:"maxLength,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// newRequiredMetadataValidator returns a requiredMetadataValidator
+// configured with the requirements listed under `labels` and `annotations`
+// in the rule params
+func newRequiredMetadataValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	params := struct {
+		Labels      []metadataRequirement `json:"labels"`
+		Annotations []metadataRequirement `json:"annotations"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	for kind, requirements := range map[string][]metadataRequirement{"label": params.Labels, "annotation": params.Annotations} {
+		keys := map[string]bool{}
+		for i := range requirements {
+			r := &requirements[i]
+			if err := r.check(); err != nil {
+				return nil, fmt.Errorf("invalid %s requirement %q in %q: %v", kind, r.Key, rule.Name, err)
+			}
+			if keys[r.Key] {
+				return nil, fmt.Errorf("%s %q is required more than once in %q", kind, r.Key, rule.Name)
+			}
+			keys[r.Key] = true
+		}
+	}
+
+	return requiredMetadataValidator{Logger: logger, Labels: params.Labels, Annotations: params.Annotations}, nil
+}
+
+// check compiles the pattern and returns an error if the requirement is
+// invalid
+func (r *metadataRequirement) check() error {
+	if r.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if r.MaxLength < 0 {
+		return fmt.Errorf("negative max length %d", r.MaxLength)
+	}
+
+	if r.Pattern != "" {
+		p, err := regexp.Compile("^(?:" + r.Pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+		r.pattern = p
+	}
+
+	return nil
+}
+
+// Name returns the name of requiredMetadataValidator
+func (r requiredMetadataValidator) Name() string {
+	return "required_metadata"
+}
+
+// Validate inspects the labels and annotations of a given pod and returns
+// validation. The returned validation is only valid if the pod has all
+// required labels and annotations, with values matching their constraints.
+// On UPDATE only new violations are reported, so that existing workloads
+// remain editable until they are fixed.
+func (r requiredMetadataValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	return validateViolations(req, pod, r.violations, "valid labels and annotations"), nil
+}
+
+// violations returns a reason for each missing or invalid label and
+// annotation
+func (r requiredMetadataValidator) violations(pod *corev1.Pod) []string {
+	var reasons []string
+	for _, l := range r.Labels {
+		if reason := l.violation("label", pod.Labels); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	for _, a := range r.Annotations {
+		if reason := a.violation("annotation", pod.Annotations); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons
+}
+
+// violation returns why the requirement is not met by the given labels or
+// annotations, or an empty string
+func (r metadataRequirement) violation(kind string, metadata map[string]string) string {
+	value, ok := metadata[r.Key]
+	if !ok {
+		if r.Optional {
+			return ""
+		}
+		return fmt.Sprintf("missing %s %q", kind, r.Key)
+	}
+
+	if len(r.Values) > 0 && !contains(r.Values, value) {
+		return fmt.Sprintf("%s %s=%q is not one of %s", kind, r.Key, value, strings.Join(r.Values, ", "))
+	}
+	if r.MaxLength > 0 && len(value) > r.MaxLength {
+		return fmt.Sprintf("%s %s=%q is longer than %d characters", kind, r.Key, value, r.MaxLength)
+	}
+	if r.pattern != nil && !r.pattern.MatchString(value) {
+		return fmt.Sprintf("%s %s=%q does not match %s", kind, r.Key, value, r.Pattern)
+	}
+
+	return ""
+}
+
+// contains returns true if s is in values
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequiredMetadataValidatorValidate(t *testing.T) {
+	rule := config.Rule{
+		Name: "required_metadata",
+		Params: json.RawMessage(`{
+			"labels": [
+				{"key": "team", "pattern": "[a-z][a-z-]*", "maxLength": 20},
+				{"key": "tier", "optional": true, "values": ["frontend", "backend"]}
+			],
+			"annotations": [
+				{"key": "acme.com/cost-center", "pattern": "cc-[0-9]+"}
+			]
+		}`),
+	}
+	rv, err := newRequiredMetadataValidator(logger(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		valid       bool
+		reason      string
+	}{
+		{
+			name:        "valid",
+			labels:      map[string]string{"team": "payments", "tier": "backend"},
+			annotations: map[string]string{"acme.com/cost-center": "cc-42"},
+			valid:       true,
+			reason:      "valid labels and annotations",
+		},
+		{
+			name:   "missing",
+			valid:  false,
+			reason: `missing label "team", missing annotation "acme.com/cost-center"`,
+		},
+		{
+			name:        "invalid values",
+			labels:      map[string]string{"team": "Payments", "tier": "db"},
+			annotations: map[string]string{"acme.com/cost-center": "cc-42x"},
+			valid:       false,
+			reason: `label team="Payments" does not match [a-z][a-z-]*, ` +
+				`label tier="db" is not one of frontend, backend, ` +
+				`annotation acme.com/cost-center="cc-42x" does not match cc-[0-9]+`,
+		},
+		{
+			name:        "too long",
+			labels:      map[string]string{"team": "payments-and-billing-team"},
+			annotations: map[string]string{"acme.com/cost-center": "cc-42"},
+			valid:       false,
+			reason:      `label team="payments-and-billing-team" is longer than 20 characters`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Labels: tc.labels, Annotations: tc.annotations}}
+
+			v, err := rv.Validate(context.Background(), createRequest(), pod)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.valid, v.Valid)
+			assert.Equal(t, tc.reason, v.Reason)
+		})
+	}
+
+	// existing violations don't block updates, new ones do
+	old := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{"acme.com/cost-center": "cc-42"}}}
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Labels: map[string]string{"tier": "db"}}}
+	v, err := rv.Validate(context.Background(), &request.Request{Operation: admissionv1.Update, OldObject: old}, pod)
+	assert.Nil(t, err)
+	assert.False(t, v.Valid)
+	assert.Equal(t, `label tier="db" is not one of frontend, backend, missing annotation "acme.com/cost-center"`, v.Reason)
+}
+
+func TestNewRequiredMetadataValidatorInvalid(t *testing.T) {
+	for name, params := range map[string]string{
+		"no key":          `{"labels":[{"pattern":"a"}]}`,
+		"duplicated key":  `{"annotations":[{"key":"a"},{"key":"a"}]}`,
+		"bad pattern":     `{"labels":[{"key":"team","pattern":"("}]}`,
+		"negative length": `{"labels":[{"key":"team","maxLength":-1}]}`,
+		"unknown field":   `{"labels":[{"key":"team","regex":"a"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newRequiredMetadataValidator(logger(), config.Rule{Name: "required_metadata", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
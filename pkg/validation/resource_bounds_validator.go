@@ -0,0 +1,217 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/scope"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resourceBoundsValidator is a container for validating the resource requests
+// and limits of pod containers, it is the companion of the
+// default_resources mutation
+type resourceBoundsValidator struct {
+	Logger logrus.FieldLogger
+	Bounds []resourceBounds
+}
+
+// resourceBoundsValidator implements the podValidator interface
+var _ podValidator = (*resourceBoundsValidator)(nil)
+
+// resourceBounds are the bounds enforced on the containers of the pods in
+// scope, with the same semantics as a LimitRange of type Container
+type resourceBounds struct {
+	// Scope restricts the bounds to some namespaces or pods, all pods are in
+	// scope if unset
+	Scope *config.Scope `json:"scope,omitempty"`
+	// Min is the minimum request of each resource, a request is then required
+	Min corev1.ResourceList `json:"min,omitempty"`
+	// Max is the maximum limit of each resource, a limit is then required
+	Max corev1.ResourceList `json:"max,omitempty"`
+	// MaxLimitRequestRatio is the maximum limit divided by the request of
+	// each resource, both are then required
+	MaxLimitRequestRatio corev1.ResourceList `json:"maxLimitRequestRatio,omitempty"`
+
+	scope *scope.Scope
+}
+
+// newResourceBoundsValidator returns a resourceBoundsValidator configured
+// with the bounds listed under `bounds` in the rule params, the first bounds
+// in scope of a pod apply
+func newResourceBoundsValidator(logger logrus.FieldLogger, rule config.Rule) (podValidator, error) {
+	params := struct {
+		Bounds []resourceBounds `json:"bounds"`
+	}{}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	for i := range params.Bounds {
+		b := &params.Bounds[i]
+		if err := b.check(); err != nil {
+			return nil, fmt.Errorf("invalid resource bounds %d in %q: %v", i, rule.Name, err)
+		}
+	}
+
+	return resourceBoundsValidator{Logger: logger, Bounds: params.Bounds}, nil
+}
+
+// check parses the scope and returns an error if the bounds are invalid
+func (b *resourceBounds) check() error {
+	for name, min := range b.Min {
+		if max, ok := b.Max[name]; ok && min.Cmp(max) > 0 {
+			return fmt.Errorf("%s min %s is greater than the max %s", name, min.String(), max.String())
+		}
+	}
+	for name, ratio := range b.MaxLimitRequestRatio {
+		if ratio.Cmp(resource.MustParse("1")) < 0 {
+			return fmt.Errorf("%s max limit to request ratio %s is lower than 1", name, ratio.String())
+		}
+	}
+
+	sc, err := scope.New(b.Scope)
+	if err != nil {
+		return fmt.Errorf("invalid scope: %v", err)
+	}
+	b.scope = sc
+
+	return nil
+}
+
+// paramScopes returns the scopes of the bounds
+func (r resourceBoundsValidator) paramScopes() []*scope.Scope {
+	scopes := make([]*scope.Scope, len(r.Bounds))
+	for i, b := range r.Bounds {
+		scopes[i] = b.scope
+	}
+	return scopes
+}
+
+// Name returns the name of resourceBoundsValidator
+func (r resourceBoundsValidator) Name() string {
+	return "resource_bounds"
+}
+
+// Validate inspects the resources of the containers and init containers of a
+// given pod and returns validation. The returned validation is only valid if
+// they are within the first bounds in scope of the pod. Pod resources are
+// immutable so only new pods and pod templates are validated, on UPDATE of
+// a template only violations the old template didn't have are denied.
+func (r resourceBoundsValidator) Validate(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	switch {
+	case req.Operation == admissionv1.Create:
+	case req.Operation == admissionv1.Update && req.Template:
+	default:
+		return validation{Valid: true, Reason: "resources already validated on create"}, nil
+	}
+
+	b, err := r.bounds(req, pod)
+	if err != nil {
+		return validation{}, err
+	}
+	if b == nil {
+		return validation{Valid: true, Reason: "valid resources"}, nil
+	}
+
+	return validateViolations(req, pod, b.podViolations, "valid resources"), nil
+}
+
+// bounds returns the first bounds in scope of the pod, nil if there are none
+func (r resourceBoundsValidator) bounds(req *request.Request, pod *corev1.Pod) (*resourceBounds, error) {
+	for i := range r.Bounds {
+		inScope, err := r.Bounds[i].scope.Matches(req, pod)
+		if err != nil {
+			return nil, err
+		}
+		if inScope {
+			return &r.Bounds[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// podViolations returns the reasons the resources of the pod containers and
+// init containers are out of bounds
+func (b resourceBounds) podViolations(pod *corev1.Pod) []string {
+	var reasons []string
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			reasons = append(reasons, b.violations(c)...)
+		}
+	}
+	return reasons
+}
+
+// violations returns the reasons the container resources are out of bounds.
+// A container without a request for a resource is considered to request its
+// limit, as the API server defaults it.
+func (b resourceBounds) violations(c corev1.Container) []string {
+	limits := c.Resources.Limits
+	requests := corev1.ResourceList{}
+	for name, q := range limits {
+		requests[name] = q
+	}
+	for name, q := range c.Resources.Requests {
+		requests[name] = q
+	}
+
+	var reasons []string
+	for _, name := range resourceNames(b.Min) {
+		min := b.Min[name]
+		request, ok := requests[name]
+		switch {
+		case !ok:
+			reasons = append(reasons, fmt.Sprintf("container %q has no %s request, the min is %s", c.Name, name, min.String()))
+		case request.Cmp(min) < 0:
+			reasons = append(reasons, fmt.Sprintf("container %q %s request %s is below the min of %s", c.Name, name, request.String(), min.String()))
+		}
+	}
+
+	for _, name := range resourceNames(b.Max) {
+		max := b.Max[name]
+		limit, ok := limits[name]
+		switch {
+		case !ok:
+			reasons = append(reasons, fmt.Sprintf("container %q has no %s limit, the max is %s", c.Name, name, max.String()))
+		case limit.Cmp(max) > 0:
+			reasons = append(reasons, fmt.Sprintf("container %q %s limit %s is above the max of %s", c.Name, name, limit.String(), max.String()))
+		}
+	}
+
+	for _, name := range resourceNames(b.MaxLimitRequestRatio) {
+		ratio := b.MaxLimitRequestRatio[name]
+		limit, hasLimit := limits[name]
+		request, hasRequest := requests[name]
+		if !hasLimit || !hasRequest || request.IsZero() {
+			reasons = append(reasons, fmt.Sprintf("container %q needs a %s request and limit, the max limit to request ratio is %s",
+				c.Name, name, ratio.String()))
+			continue
+		}
+
+		r := float64(limit.MilliValue()) / float64(request.MilliValue())
+		if r > float64(ratio.MilliValue())/1000 {
+			reasons = append(reasons, fmt.Sprintf("container %q %s limit to request ratio %.3g is above the max of %s",
+				c.Name, name, r, ratio.String()))
+		}
+	}
+
+	return reasons
+}
+
+// resourceNames returns the sorted resource names of the list, so that
+// reasons are stable
+func resourceNames(list corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
@@ -0,0 +1,157 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// boundsRule returns a resource_bounds rule with looser bounds in the batch
+// namespace than everywhere else
+func boundsRule() config.Rule {
+	return config.Rule{
+		Name: "resource_bounds",
+		Params: json.RawMessage(`{"bounds":[
+			{
+				"scope": {"namespaces": ["batch"]},
+				"max": {"memory": "64Gi"}
+			},
+			{
+				"min": {"cpu": "10m"},
+				"max": {"memory": "4Gi"},
+				"maxLimitRequestRatio": {"memory": "2"}
+			}
+		]}`),
+	}
+}
+
+func TestResourceBoundsValidatorValidate(t *testing.T) {
+	rv, err := newResourceBoundsValidator(logger(), boundsRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		namespace string
+		requests  corev1.ResourceList
+		limits    corev1.ResourceList
+		valid     bool
+		reason    string
+	}{
+		{
+			name:     "valid",
+			requests: corev1.ResourceList{"cpu": resource.MustParse("100m"), "memory": resource.MustParse("1Gi")},
+			limits:   corev1.ResourceList{"memory": resource.MustParse("2Gi")},
+			valid:    true,
+			reason:   "valid resources",
+		},
+		{
+			name:   "request defaulted to the limit",
+			limits: corev1.ResourceList{"cpu": resource.MustParse("1"), "memory": resource.MustParse("2Gi")},
+			valid:  true,
+			reason: "valid resources",
+		},
+		{
+			name:  "missing",
+			valid: false,
+			reason: `container "app" has no cpu request, the min is 10m, ` +
+				`container "app" has no memory limit, the max is 4Gi, ` +
+				`container "app" needs a memory request and limit, the max limit to request ratio is 2`,
+		},
+		{
+			name:     "out of bounds",
+			requests: corev1.ResourceList{"cpu": resource.MustParse("5m"), "memory": resource.MustParse("1Gi")},
+			limits:   corev1.ResourceList{"memory": resource.MustParse("8Gi")},
+			valid:    false,
+			reason: `container "app" cpu request 5m is below the min of 10m, ` +
+				`container "app" memory limit 8Gi is above the max of 4Gi, ` +
+				`container "app" memory limit to request ratio 8 is above the max of 2`,
+		},
+		{
+			name:      "other bounds in scope",
+			namespace: "batch",
+			limits:    corev1.ResourceList{"memory": resource.MustParse("32Gi")},
+			valid:     true,
+			reason:    "valid resources",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Name: "resources", Namespace: tc.namespace},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:      "app",
+					Resources: corev1.ResourceRequirements{Requests: tc.requests, Limits: tc.limits},
+				}}},
+			}
+
+			v, err := rv.Validate(context.Background(), createRequest(), pod)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.valid, v.Valid)
+			assert.Equal(t, tc.reason, v.Reason)
+		})
+	}
+
+	// pod resources are immutable
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	v, err := rv.Validate(context.Background(), &request.Request{Operation: admissionv1.Update}, pod)
+	assert.Nil(t, err)
+	assert.True(t, v.Valid)
+}
+
+func TestResourceBoundsValidatorValidateTemplateUpdate(t *testing.T) {
+	rv, err := newResourceBoundsValidator(logger(), boundsRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the template predates the rule and has no cpu request
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{"memory": resource.MustParse("1Gi")},
+		Limits:   corev1.ResourceList{"memory": resource.MustParse("2Gi")},
+	}
+	template := func(resources corev1.ResourceRequirements, image string) *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Image: image, Resources: resources},
+		}}}
+	}
+	old := template(resources, "app:v1")
+	req := &request.Request{Operation: admissionv1.Update, Template: true, OldObject: old}
+
+	// unrelated changes are allowed despite the existing violation
+	v, err := rv.Validate(context.Background(), req, template(resources, "app:v2"))
+	assert.Nil(t, err)
+	assert.True(t, v.Valid)
+	assert.Equal(t, "valid resources", v.Reason)
+
+	// new violations are still denied
+	bigger := *resources.DeepCopy()
+	bigger.Limits["memory"] = resource.MustParse("8Gi")
+	v, err = rv.Validate(context.Background(), req, template(bigger, "app:v2"))
+	assert.Nil(t, err)
+	assert.False(t, v.Valid)
+	assert.Equal(t, `container "app" memory limit 8Gi is above the max of 4Gi, `+
+		`container "app" memory limit to request ratio 8 is above the max of 2`, v.Reason)
+}
+
+func TestNewResourceBoundsValidatorInvalid(t *testing.T) {
+	for name, params := range map[string]string{
+		"min above max": `{"bounds":[{"min":{"cpu":"2"},"max":{"cpu":"1"}}]}`,
+		"ratio below 1": `{"bounds":[{"maxLimitRequestRatio":{"cpu":"0.5"}}]}`,
+		"unknown bound": `{"bounds":[{"default":{"cpu":"1"}}]}`,
+		"bad scope":     `{"bounds":[{"scope":{"names":["["]}}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newResourceBoundsValidator(logger(), config.Rule{Name: "resource_bounds", Params: json.RawMessage(params)})
+			assert.Error(t, err)
+		})
+	}
+}
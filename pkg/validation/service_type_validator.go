@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// serviceTypeValidator is a container for validating the type of services
+type serviceTypeValidator struct {
+	Logger  logrus.FieldLogger
+	Allowed []corev1.ServiceType
+}
+
+// serviceTypeValidator implements the serviceValidator interface
+var _ serviceValidator = (*serviceTypeValidator)(nil)
+
+// newServiceTypeValidator returns a serviceTypeValidator configured with the
+// rule params, allowed types are listed under `allowed` and default to
+// ClusterIP only
+func newServiceTypeValidator(logger logrus.FieldLogger, rule config.Rule) (serviceValidator, error) {
+	params := struct {
+		Allowed []corev1.ServiceType `json:"allowed"`
+	}{
+		Allowed: []corev1.ServiceType{corev1.ServiceTypeClusterIP},
+	}
+	if err := rule.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	return serviceTypeValidator{Logger: logger, Allowed: params.Allowed}, nil
+}
+
+// Name returns the name of serviceTypeValidator
+func (s serviceTypeValidator) Name() string {
+	return "service_type"
+}
+
+// ValidateService inspects the type of a given service and returns validation.
+// The returned validation is only valid if the service type is allowed, an
+// unset type defaults to ClusterIP. On UPDATE only type changes are validated
+// so that existing services remain editable.
+func (s serviceTypeValidator) ValidateService(ctx context.Context, req *request.Request, svc *corev1.Service) (validation, error) {
+	switch req.Operation {
+	case admissionv1.Create:
+	case admissionv1.Update:
+		if old := req.OldService(); old != nil && serviceType(old) == serviceType(svc) {
+			return validation{Valid: true, Reason: "service type unchanged"}, nil
+		}
+	default:
+		return validation{Valid: true, Reason: "nothing to validate"}, nil
+	}
+
+	t := serviceType(svc)
+
+	for _, a := range s.Allowed {
+		if t == a {
+			return validation{Valid: true, Reason: "valid service type"}, nil
+		}
+	}
+
+	v := validation{
+		Valid:  false,
+		Reason: fmt.Sprintf("service type %q is not allowed, must be one of %q", t, s.Allowed),
+	}
+	return v, nil
+}
+
+// serviceType returns the type of a service, ClusterIP if unset
+func serviceType(svc *corev1.Service) corev1.ServiceType {
+	if svc.Spec.Type == "" {
+		return corev1.ServiceTypeClusterIP
+	}
+	return svc.Spec.Type
+}
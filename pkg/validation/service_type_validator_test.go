@@ -0,0 +1,76 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceTypeValidatorValidateService(t *testing.T) {
+	svc := func(t corev1.ServiceType) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: v1.ObjectMeta{Name: "svc"},
+			Spec:       corev1.ServiceSpec{Type: t},
+		}
+	}
+
+	t.Run("default", func(t *testing.T) {
+		sv, err := newServiceTypeValidator(logger(), config.Rule{Name: "service_type"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := sv.ValidateService(context.Background(), createRequest(), svc(""))
+		assert.Nil(t, err)
+		assert.True(t, v.Valid)
+
+		v, err = sv.ValidateService(context.Background(), createRequest(), svc(corev1.ServiceTypeNodePort))
+		assert.Nil(t, err)
+		assert.False(t, v.Valid)
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		rule := config.Rule{
+			Name:   "service_type",
+			Params: json.RawMessage(`{"allowed":["ClusterIP","NodePort"]}`),
+		}
+		sv, err := newServiceTypeValidator(logger(), rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := sv.ValidateService(context.Background(), createRequest(), svc(corev1.ServiceTypeNodePort))
+		assert.Nil(t, err)
+		assert.True(t, v.Valid)
+
+		v, err = sv.ValidateService(context.Background(), createRequest(), svc(corev1.ServiceTypeLoadBalancer))
+		assert.Nil(t, err)
+		assert.False(t, v.Valid)
+	})
+}
+
+func TestServiceTypeValidatorValidateServiceUpdate(t *testing.T) {
+	sv, err := newServiceTypeValidator(logger(), config.Rule{Name: "service_type"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodePort := &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort}}
+	clusterIP := &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}}
+
+	// services predating the policy can still be updated
+	v, err := sv.ValidateService(context.Background(), &request.Request{Operation: admissionv1.Update, OldObject: nodePort}, nodePort.DeepCopy())
+	assert.Nil(t, err)
+	assert.True(t, v.Valid)
+
+	v, err = sv.ValidateService(context.Background(), &request.Request{Operation: admissionv1.Update, OldObject: clusterIP}, nodePort)
+	assert.Nil(t, err)
+	assert.False(t, v.Valid)
+}
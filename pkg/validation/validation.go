@@ -1,60 +1,382 @@
 package validation
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/deadline"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/exemption"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/metrics"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/scope"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// Validator is a container for mutation
+// Validator is a container for validation
 type Validator struct {
-	Logger *logrus.Entry
+	Logger             *logrus.Entry
+	evaluateAll        bool
+	exemptions         *exemption.Policy
+	validations        []podRule
+	serviceValidations []serviceRule
 }
 
-// NewValidator returns an initialised instance of Validator
-func NewValidator(logger *logrus.Entry) *Validator {
-	return &Validator{Logger: logger}
+// NewValidator returns an initialised instance of Validator applying the
+// validators enabled in cfg, in order
+func NewValidator(logger *logrus.Entry, cfg *config.Config) (*Validator, error) {
+	v := &Validator{
+		Logger:      logger,
+		evaluateAll: cfg.Evaluation == config.EvaluateAll,
+		exemptions:  exemption.New(cfg.Exemptions),
+	}
+
+	for _, r := range cfg.Validators {
+		sc, err := scope.New(r.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope for %q: %v", r.Name, err)
+		}
+
+		if newValidation, ok := validations[r.Name]; ok {
+			pv, err := newValidation(logger, r)
+			if err != nil {
+				return nil, err
+			}
+			v.validations = append(v.validations, podRule{podValidator: pv, mode: r.Enforcement(), scope: sc})
+			continue
+		}
+
+		if newValidation, ok := serviceValidations[r.Name]; ok {
+			sv, err := newValidation(logger, r)
+			if err != nil {
+				return nil, err
+			}
+			v.serviceValidations = append(v.serviceValidations, serviceRule{serviceValidator: sv, mode: r.Enforcement(), scope: sc})
+			continue
+		}
+
+		return nil, fmt.Errorf("unknown validator %q", r.Name)
+	}
+
+	return v, nil
+}
+
+// WithLogger returns a copy of the validator logging to the given logger, so
+// that a validator built once can log the fields of each admission request.
+// Validations are passed the logger along with the request.
+func (v *Validator) WithLogger(logger *logrus.Entry) *Validator {
+	c := *v
+	c.Logger = logger
+	return &c
 }
 
-// podValidators is an interface used to group functions mutating pods
+// NamespaceSelectors returns true if a validation, or a scope within its
+// params, is scoped by namespace selector, namespace labels then have to be
+// looked up
+func (v *Validator) NamespaceSelectors() bool {
+	var scopes []*scope.Scope
+	for _, r := range v.validations {
+		scopes = append(scopes, r.scope)
+		if p, ok := r.podValidator.(scopedParams); ok {
+			scopes = append(scopes, p.paramScopes()...)
+		}
+	}
+	for _, r := range v.serviceValidations {
+		scopes = append(scopes, r.scope)
+		if p, ok := r.serviceValidator.(scopedParams); ok {
+			scopes = append(scopes, p.paramScopes()...)
+		}
+	}
+
+	for _, s := range scopes {
+		if s.NamespaceSelector() {
+			return true
+		}
+	}
+	return false
+}
+
+// podValidators is an interface used to group functions validating pods, the
+// admission request attributes are passed along with the pod. The context is
+// done once the admission request times out, validators blocking on anything
+// must then return, see deadline.Run.
 type podValidator interface {
-	Validate(*corev1.Pod) (validation, error)
+	Validate(context.Context, *request.Request, *corev1.Pod) (validation, error)
+	Name() string
+}
+
+// serviceValidator is an interface used to group functions validating
+// services, the admission request attributes are passed along with the
+// service. The context is done once the admission request times out, like
+// pod validators they must then return.
+type serviceValidator interface {
+	ValidateService(context.Context, *request.Request, *corev1.Service) (validation, error)
 	Name() string
 }
 
+// podRule is a configured podValidator along with its enforcement mode and
+// scope
+type podRule struct {
+	podValidator
+	mode  config.Mode
+	scope *scope.Scope
+}
+
+// serviceRule is a configured serviceValidator along with its enforcement
+// mode and scope
+type serviceRule struct {
+	serviceValidator
+	mode  config.Mode
+	scope *scope.Scope
+}
+
+// scopedParams is implemented by validators whose params hold scopes of their
+// own, ie. resource_bounds
+type scopedParams interface {
+	paramScopes() []*scope.Scope
+}
+
+// validations lists all known pod validators by name, each entry builds a
+// validator from its config rule
+var validations = map[string]func(logrus.FieldLogger, config.Rule) (podValidator, error){
+	"name_validator":            newNameValidator,
+	"lifespan_label":            newLifespanValidator,
+	"resource_bounds":           newResourceBoundsValidator,
+	"image_policy":              newImagePolicyValidator,
+	"privileged":                newPrivilegedValidator,
+	"host_namespaces":           newHostNamespacesValidator,
+	"host_path":                 newHostPathValidator,
+	"capabilities":              newCapabilitiesValidator,
+	"run_as_non_root":           newRunAsNonRootValidator,
+	"read_only_root_filesystem": newReadOnlyRootFilesystemValidator,
+	"required_metadata":         newRequiredMetadataValidator,
+}
+
+// serviceValidations lists all known service validators by name, each entry
+// builds a validator from its config rule
+var serviceValidations = map[string]func(logrus.FieldLogger, config.Rule) (serviceValidator, error){
+	"service_type": newServiceTypeValidator,
+}
+
 type validation struct {
 	Valid  bool
 	Reason string
+	// Warnings are surfaced to the client without denying the object, a
+	// validator can warn about an object it still considers valid
+	Warnings []string
+	// Failures lists every failed validator, it is only set by ValidatePod
+	// and ValidateService
+	Failures []Failure
+}
+
+// Failure is the reason a given validator failed an object
+type Failure struct {
+	Validator string
+	Reason    string
 }
 
 // ValidatePod returns true if a pod is valid
-func (v *Validator) ValidatePod(pod *corev1.Pod) (validation, error) {
-	var podName string
-	if pod.Name != "" {
-		podName = pod.Name
-	} else {
-		if pod.ObjectMeta.GenerateName != "" {
-			podName = pod.ObjectMeta.GenerateName
-		}
+func (v *Validator) ValidatePod(ctx context.Context, req *request.Request, pod *corev1.Pod) (validation, error) {
+	req = req.WithLogger(v.Logger)
+	checks := make([]check, len(v.validations))
+	for i, pv := range v.validations {
+		pv := pv
+		checks[i] = check{pv.Name(), pv.mode, pv.scope, func(ctx context.Context) (validation, error) { return pv.Validate(ctx, req, pod) }}
 	}
-	log := logrus.WithField("pod_name", podName)
-	log.Print("delete me")
 
-	// list of all validations to be applied to the pod
-	validations := []podValidator{
-		nameValidator{v.Logger},
+	return v.runChecks(ctx, req, pod, checks, "valid pod")
+}
+
+// ValidateService returns true if a service is valid
+func (v *Validator) ValidateService(ctx context.Context, req *request.Request, svc *corev1.Service) (validation, error) {
+	req = req.WithLogger(v.Logger)
+	checks := make([]check, len(v.serviceValidations))
+	for i, sv := range v.serviceValidations {
+		sv := sv
+		checks[i] = check{sv.Name(), sv.mode, sv.scope, func(ctx context.Context) (validation, error) { return sv.ValidateService(ctx, req, svc) }}
 	}
 
-	// apply all validations
-	for _, v := range validations {
-		var err error
-		vp, err := v.Validate(pod)
+	return v.runChecks(ctx, req, svc, checks, "valid service")
+}
+
+// check is a single named validation bound to the object it validates, the
+// context of the admission request is passed to validate
+type check struct {
+	name     string
+	mode     config.Mode
+	scope    *scope.Scope
+	validate func(context.Context) (validation, error)
+}
+
+// exemptionsFailure is the validator name of failures of pod templates asking
+// for exemptions that are not granted
+const exemptionsFailure = "exemptions"
+
+// runChecks applies all checks in order. Pod templates asking for exemptions
+// that are not granted are denied, see exemption.Policy.CheckTemplate. It
+// stops at the first failure unless all validators are evaluated, in which
+// case all failure reasons are combined. Failures of checks in warn mode are
+// turned into warnings, failures of checks in dry-run mode are only logged.
+// Errors of checks in either mode, timeouts included, are logged and the
+// check skipped, only errors of enforced checks are returned. Checks are
+// skipped for objects out of their scope and for objects exempted from them.
+// An error wrapping ctx.Err() is returned if ctx is done before all enforced
+// checks complete.
+func (v *Validator) runChecks(ctx context.Context, req *request.Request, obj metav1.Object, checks []check, validReason string) (validation, error) {
+	if err := v.exemptions.CheckTemplate(req, obj); err != nil {
+		v.Logger.WithField("user", req.UserInfo.Username).Infof("template denied: %v", err)
+		return validation{
+			Valid:    false,
+			Reason:   err.Error(),
+			Failures: []Failure{{Validator: exemptionsFailure, Reason: err.Error()}},
+		}, nil
+	}
+
+	var failures []Failure
+	exemptions, warnings := v.exemptions.Exempted(req, obj)
+	for _, c := range checks {
+		inScope, err := c.scope.Matches(req, obj)
+		if err != nil {
+			err = fmt.Errorf("could not match scope of %q: %v", c.name, err)
+			if v.skipError(c, err) {
+				continue
+			}
+			return validation{Valid: false, Reason: err.Error(), Warnings: warnings}, err
+		}
+		if !inScope {
+			v.Logger.WithField("validation", c.name).Trace("object out of scope")
+			continue
+		}
+
+		if exemptions.Exempts(c.name) {
+			v.Logger.WithField("validation", c.name).WithField("user", req.UserInfo.Username).
+				Info("object exempted from validation")
+			metrics.RuleExemptions.WithLabelValues(metrics.KindValidator, c.name).Inc()
+			continue
+		}
+
+		// the results of a check still running past the deadline are never
+		// read
+		var vp validation
+		var validateErr error
+		start := time.Now()
+		err = deadline.Run(ctx, fmt.Sprintf("validation %q", c.name), func() {
+			vp, validateErr = c.validate(ctx)
+		})
+		metrics.ObserveRule(metrics.KindValidator, c.name, start)
 		if err != nil {
-			return validation{Valid: false, Reason: err.Error()}, err
+			if v.skipError(c, err) {
+				continue
+			}
+			return validation{Valid: false, Reason: err.Error(), Warnings: warnings}, err
+		}
+		if validateErr != nil {
+			if v.skipError(c, validateErr) {
+				continue
+			}
+			return validation{Valid: false, Reason: validateErr.Error(), Warnings: warnings}, validateErr
+		}
+
+		log := v.Logger.WithField("validation", c.name)
+		if c.mode == config.ModeDryRun {
+			for _, w := range vp.Warnings {
+				log.Infof("validation warning in dry-run mode: %s", w)
+			}
+		} else {
+			for _, w := range vp.Warnings {
+				log.Debugf("validation warning: %s", w)
+				warnings = append(warnings, fmt.Sprintf("%s: %s", c.name, w))
+			}
+		}
+		if vp.Valid {
+			continue
+		}
+
+		metrics.RuleTriggers.WithLabelValues(metrics.KindValidator, c.name, string(c.mode)).Inc()
+		switch c.mode {
+		case config.ModeWarn:
+			log.Debugf("validation failed in warn mode: %s", vp.Reason)
+			warnings = append(warnings, fmt.Sprintf("%s: %s", c.name, vp.Reason))
+			continue
+		case config.ModeDryRun:
+			log.Infof("validation failed in dry-run mode: %s", vp.Reason)
+			continue
+		}
+
+		log.Debugf("validation failed: %s", vp.Reason)
+		failures = append(failures, Failure{Validator: c.name, Reason: vp.Reason})
+		if !v.evaluateAll {
+			break
+		}
+	}
+
+	switch len(failures) {
+	case 0:
+		return validation{Valid: true, Reason: validReason, Warnings: warnings}, nil
+	case 1:
+		return validation{
+			Valid:    false,
+			Reason:   failures[0].Reason,
+			Warnings: warnings,
+			Failures: failures,
+		}, nil
+	}
+
+	reasons := make([]string, len(failures))
+	for i, f := range failures {
+		reasons[i] = fmt.Sprintf("%s: %s", f.Validator, f.Reason)
+	}
+	reason := fmt.Sprintf("%d policy violations: %s", len(failures), strings.Join(reasons, "; "))
+
+	return validation{Valid: false, Reason: reason, Warnings: warnings, Failures: failures}, nil
+}
+
+// skipError counts the error of a check and returns true if the check is not
+// enforced, the error is then logged and the object validated as if the check
+// had not run
+func (v *Validator) skipError(c check, err error) bool {
+	metrics.RuleErrors.WithLabelValues(metrics.KindValidator, c.name, string(c.mode)).Inc()
+	if c.mode == config.ModeEnforce {
+		return false
+	}
+
+	v.Logger.WithField("validation", c.name).Warnf("validation in %s mode failed: %v", c.mode, err)
+	return true
+}
+
+// validateViolations returns a validation only valid if the pod has no
+// violations, as listed by the given function. On UPDATE violations the old
+// pod already had are ignored, so that pods and workloads created before a
+// rule was enabled can still be updated, while changes are validated.
+func validateViolations(req *request.Request, pod *corev1.Pod, violations func(*corev1.Pod) []string, validReason string) validation {
+	switch req.Operation {
+	case admissionv1.Create, admissionv1.Update:
+	default:
+		return validation{Valid: true, Reason: "nothing to validate"}
+	}
+
+	existing := map[string]bool{}
+	if old := req.OldPod(); old != nil {
+		for _, r := range violations(old) {
+			existing[r] = true
 		}
-		if !vp.Valid {
-			return validation{Valid: false, Reason: vp.Reason}, err
+	}
+
+	var reasons []string
+	for _, r := range violations(pod) {
+		if !existing[r] {
+			reasons = append(reasons, r)
 		}
 	}
 
-	return validation{Valid: true, Reason: "valid pod"}, nil
+	if len(reasons) > 0 {
+		return validation{Valid: false, Reason: strings.Join(reasons, ", ")}
+	}
+
+	return validation{Valid: true, Reason: validReason}
 }
@@ -1,17 +1,29 @@
 package validation
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/config"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/metrics"
+	"github.com/slackhq/simple-kubernetes-webhook/pkg/request"
 	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestValidatePod(t *testing.T) {
-	v := NewValidator(logger())
+	v, err := NewValidator(logger(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	pod := &corev1.Pod{
 		ObjectMeta: v1.ObjectMeta{
@@ -25,13 +37,324 @@ func TestValidatePod(t *testing.T) {
 		},
 	}
 
-	val, err := v.ValidatePod(pod)
+	val, err := v.ValidatePod(context.Background(), createRequest(), pod)
 	assert.Nil(t, err)
 	assert.True(t, val.Valid)
 }
 
+// createRequest returns the attributes of a CREATE admission request
+func createRequest() *request.Request {
+	return &request.Request{Operation: admissionv1.Create}
+}
+
 func logger() *logrus.Entry {
 	mute := logrus.StandardLogger()
 	mute.Out = ioutil.Discard
 	return mute.WithField("logger", "test")
 }
+
+func TestNewValidator(t *testing.T) {
+	cfg := &config.Config{Validators: []config.Rule{{Name: "nope"}}}
+	_, err := NewValidator(logger(), cfg)
+	assert.Error(t, err)
+}
+
+func TestValidatePodEvaluation(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "offensive",
+		},
+	}
+
+	rules := []config.Rule{{Name: "name_validator"}, {Name: "name_validator"}}
+
+	t.Run("first", func(t *testing.T) {
+		v, err := NewValidator(logger(), &config.Config{Validators: rules})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		val, err := v.ValidatePod(context.Background(), createRequest(), pod)
+		assert.Nil(t, err)
+		assert.False(t, val.Valid)
+		assert.Equal(t, `pod name contains "offensive"`, val.Reason)
+		assert.Len(t, val.Failures, 1)
+	})
+
+	t.Run("all", func(t *testing.T) {
+		cfg := &config.Config{Validators: rules, Evaluation: config.EvaluateAll}
+		v, err := NewValidator(logger(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		val, err := v.ValidatePod(context.Background(), createRequest(), pod)
+		assert.Nil(t, err)
+		assert.False(t, val.Valid)
+		assert.Equal(t, `2 policy violations: name_validator: pod name contains "offensive"; `+
+			`name_validator: pod name contains "offensive"`, val.Reason)
+
+		want := []Failure{
+			{Validator: "name_validator", Reason: `pod name contains "offensive"`},
+			{Validator: "name_validator", Reason: `pod name contains "offensive"`},
+		}
+		assert.Equal(t, want, val.Failures)
+	})
+}
+
+// warnValidator is a validator warning about every pod
+type warnValidator struct{}
+
+func (warnValidator) Name() string { return "warn" }
+
+func (warnValidator) Validate(context.Context, *request.Request, *corev1.Pod) (validation, error) {
+	return validation{Valid: true, Reason: "valid", Warnings: []string{"careful"}}, nil
+}
+
+func TestValidatePodWarnings(t *testing.T) {
+	v := &Validator{
+		Logger: logger(),
+		validations: []podRule{
+			{podValidator: warnValidator{}, mode: config.ModeEnforce},
+			{podValidator: nameValidator{Logger: logger()}, mode: config.ModeEnforce},
+		},
+	}
+
+	val, err := v.ValidatePod(context.Background(), createRequest(), &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "lifespan"}})
+	assert.Nil(t, err)
+	assert.True(t, val.Valid)
+	assert.Equal(t, []string{"warn: careful"}, val.Warnings)
+
+	val, err = v.ValidatePod(context.Background(), createRequest(), &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "offensive"}})
+	assert.Nil(t, err)
+	assert.False(t, val.Valid)
+	assert.Equal(t, []string{"warn: careful"}, val.Warnings)
+}
+
+// slowValidator is a validator only returning once released
+type slowValidator struct{ release chan struct{} }
+
+func (slowValidator) Name() string { return "slow" }
+
+func (s slowValidator) Validate(context.Context, *request.Request, *corev1.Pod) (validation, error) {
+	<-s.release
+	return validation{Valid: true}, nil
+}
+
+func TestValidatePodTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	v := &Validator{
+		Logger: logger(),
+		validations: []podRule{
+			{podValidator: nameValidator{Logger: logger()}, mode: config.ModeEnforce},
+			{podValidator: slowValidator{release: release}, mode: config.ModeEnforce},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	val, err := v.ValidatePod(ctx, createRequest(), &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "lifespan"}})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.EqualError(t, err, `validation "slow" did not complete: context deadline exceeded`)
+	assert.False(t, val.Valid)
+}
+
+func TestValidatePodDryRunTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	v := &Validator{
+		Logger: logger(),
+		validations: []podRule{
+			{podValidator: nameValidator{Logger: logger()}, mode: config.ModeEnforce},
+			{podValidator: slowValidator{release: release}, mode: config.ModeDryRun},
+		},
+	}
+	before := testutil.ToFloat64(metrics.RuleErrors.WithLabelValues(metrics.KindValidator, "slow", string(config.ModeDryRun)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	val, err := v.ValidatePod(ctx, createRequest(), &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "lifespan"}})
+	assert.Nil(t, err)
+	assert.True(t, val.Valid)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.RuleErrors.WithLabelValues(metrics.KindValidator, "slow", string(config.ModeDryRun))))
+}
+
+func TestValidatePodModes(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name: "offensive",
+		},
+	}
+
+	for _, tc := range []struct {
+		mode     config.Mode
+		valid    bool
+		warnings []string
+	}{
+		{config.ModeEnforce, false, nil},
+		{config.ModeWarn, true, []string{`name_validator: pod name contains "offensive"`}},
+		{config.ModeDryRun, true, nil},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			cfg := &config.Config{Validators: []config.Rule{{Name: "name_validator", Mode: tc.mode}}}
+			v, err := NewValidator(logger(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			val, err := v.ValidatePod(context.Background(), createRequest(), pod)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.valid, val.Valid)
+			assert.Equal(t, tc.warnings, val.Warnings)
+		})
+	}
+}
+
+// failingValidator is a validator always returning an error
+type failingValidator struct{}
+
+func (failingValidator) Name() string { return "failing" }
+
+func (failingValidator) Validate(context.Context, *request.Request, *corev1.Pod) (validation, error) {
+	return validation{}, errors.New("broken")
+}
+
+func TestValidatePodModesError(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "offensive"}}
+
+	for _, tc := range []struct {
+		mode config.Mode
+		err  bool
+	}{
+		{config.ModeEnforce, true},
+		{config.ModeWarn, false},
+		{config.ModeDryRun, false},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			v := &Validator{
+				Logger: logger(),
+				validations: []podRule{
+					{podValidator: failingValidator{}, mode: tc.mode},
+					{podValidator: nameValidator{Logger: logger()}, mode: config.ModeEnforce},
+				},
+			}
+			before := testutil.ToFloat64(metrics.RuleErrors.WithLabelValues(metrics.KindValidator, "failing", string(tc.mode)))
+
+			val, err := v.ValidatePod(context.Background(), createRequest(), pod)
+			assert.Equal(t, before+1, testutil.ToFloat64(metrics.RuleErrors.WithLabelValues(metrics.KindValidator, "failing", string(tc.mode))))
+			if tc.err {
+				assert.EqualError(t, err, "broken")
+				assert.False(t, val.Valid)
+				return
+			}
+
+			// the following enforced validators still run
+			assert.Nil(t, err)
+			assert.False(t, val.Valid)
+			assert.Equal(t, `pod name contains "offensive"`, val.Reason)
+		})
+	}
+}
+
+func TestValidatePodScope(t *testing.T) {
+	cfg := &config.Config{Validators: []config.Rule{{
+		Name: "name_validator",
+		Scope: &config.Scope{ObjectSelector: &v1.LabelSelector{
+			MatchLabels: map[string]string{"app": "web"},
+		}},
+	}}}
+	v, err := NewValidator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "offensive"}}
+	val, err := v.ValidatePod(context.Background(), createRequest(), pod)
+	assert.Nil(t, err)
+	assert.True(t, val.Valid)
+
+	pod.Labels = map[string]string{"app": "web"}
+	val, err = v.ValidatePod(context.Background(), createRequest(), pod)
+	assert.Nil(t, err)
+	assert.False(t, val.Valid)
+}
+
+func TestValidatePodScopeError(t *testing.T) {
+	// namespace labels are unknown, the namespace selector can't be matched
+	scope := &config.Scope{NamespaceSelector: &v1.LabelSelector{MatchLabels: map[string]string{"tier": "prod"}}}
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "offensive"}}
+
+	cfg := &config.Config{Validators: []config.Rule{{Name: "name_validator", Mode: config.ModeDryRun, Scope: scope}}}
+	v, err := NewValidator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := v.ValidatePod(context.Background(), createRequest(), pod)
+	assert.Nil(t, err)
+	assert.True(t, val.Valid)
+
+	cfg.Validators[0].Mode = config.ModeEnforce
+	v, err = NewValidator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.ValidatePod(context.Background(), createRequest(), pod)
+	assert.Error(t, err)
+}
+
+func TestValidatePodExemptions(t *testing.T) {
+	cfg := config.Default()
+	cfg.Exemptions = &config.Exemptions{ServiceAccounts: []string{"ops:breakglass"}}
+	v, err := NewValidator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+		Name:        "offensive",
+		Annotations: map[string]string{config.DefaultExemptionAnnotation: "name_validator"},
+	}}
+
+	req := &request.Request{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "system:serviceaccount:ops:breakglass"},
+	}
+	val, err := v.ValidatePod(context.Background(), req, pod)
+	assert.Nil(t, err)
+	assert.True(t, val.Valid)
+
+	val, err = v.ValidatePod(context.Background(), createRequest(), pod)
+	assert.Nil(t, err)
+	assert.False(t, val.Valid)
+	assert.Len(t, val.Warnings, 1)
+}
+
+func TestValidatePodTemplateExemptions(t *testing.T) {
+	cfg := config.Default()
+	cfg.Exemptions = &config.Exemptions{Groups: []string{"sre"}}
+	v, err := NewValidator(logger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+		Name:        "app",
+		Annotations: map[string]string{config.DefaultExemptionAnnotation: "*"},
+	}}
+
+	// pods created from the template would inherit the exemption
+	req := &request.Request{Operation: admissionv1.Create, Template: true, UserInfo: authenticationv1.UserInfo{Username: "bob"}}
+	val, err := v.ValidatePod(context.Background(), req, pod)
+	assert.Nil(t, err)
+	assert.False(t, val.Valid)
+	assert.Equal(t, []Failure{{
+		Validator: "exemptions",
+		Reason:    `user "bob" is not allowed to exempt pods from rules with the acme.com/skip-rules annotation`,
+	}}, val.Failures)
+
+	req.UserInfo.Groups = []string{"sre"}
+	val, err = v.ValidatePod(context.Background(), req, pod)
+	assert.Nil(t, err)
+	assert.True(t, val.Valid)
+}